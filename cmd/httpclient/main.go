@@ -0,0 +1,167 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xixotron/httpfromtcp/internal/client"
+	"github.com/xixotron/httpfromtcp/internal/headers"
+)
+
+// headerFlags collects repeated -H flags.
+type headerFlags []string
+
+func (h *headerFlags) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headerFlags) Set(value string) error {
+	if !strings.Contains(value, ":") {
+		return fmt.Errorf("header %q is not of the form \"Name: value\"", value)
+	}
+	*h = append(*h, value)
+	return nil
+}
+
+func main() {
+	method := flag.String("X", "", "request method (default GET, or POST with -d)")
+	var headerList headerFlags
+	flag.Var(&headerList, "H", "request header \"Name: value\", may be repeated")
+	data := flag.String("d", "", "request body, or @file to read it from a file (@- for stdin)")
+	verbose := flag.Bool("v", false, "print the raw bytes sent and received to stderr")
+	verify := flag.Bool("verify", false, "check the body against X-Content-SHA256 and X-Content-Length trailers")
+	insecure := flag.Bool("k", false, "skip verifying the server's TLS certificate")
+	timeout := flag.Duration("timeout", 30*time.Second, "time limit for the whole exchange")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] URL\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	body, err := readBody(*data)
+	if err != nil {
+		log.Fatalf("Error reading request body: %v", err)
+	}
+	if *method == "" {
+		*method = "GET"
+		if *data != "" {
+			*method = "POST"
+		}
+	}
+
+	req, err := client.NewRequest(*method, flag.Arg(0), body)
+	if err != nil {
+		log.Fatalf("Error creating request: %v", err)
+	}
+	for _, header := range headerList {
+		name, value, _ := strings.Cut(header, ":")
+		req.Headers.Override(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	c := client.New()
+	c.Pool = nil
+	c.Timeout = *timeout
+	if *insecure {
+		c.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	if *verbose {
+		c.Trace = os.Stderr
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		log.Fatalf("Error sending request: %v", err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		log.Fatalf("Error reading response body: %v", err)
+	}
+
+	if *verbose {
+		// separates the raw exchange from the decoded response
+		fmt.Fprintln(os.Stderr)
+	}
+	printResponse(resp, respBody)
+
+	if *verify {
+		if err := verifyDigest(respBody, resp.Trailers); err != nil {
+			log.Fatalf("Verification failed: %v", err)
+		}
+		fmt.Fprintln(os.Stderr, "Body matches the X-Content-SHA256 trailer")
+	}
+}
+
+// readBody returns the body given with -d.
+func readBody(data string) ([]byte, error) {
+	name, ok := strings.CutPrefix(data, "@")
+	if !ok {
+		return []byte(data), nil
+	}
+	if name == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(name)
+}
+
+func printResponse(resp *client.Response, body []byte) {
+	fmt.Printf("%d %s\n", resp.StatusCode, resp.Reason)
+	printHeaders(resp.Headers)
+	for _, cookie := range resp.SetCookies {
+		fmt.Printf("set-cookie: %s\n", cookie)
+	}
+	fmt.Println()
+	os.Stdout.Write(body)
+	if len(body) > 0 && body[len(body)-1] != '\n' {
+		fmt.Println()
+	}
+	if len(resp.Trailers) > 0 {
+		fmt.Println("Trailers:")
+		printHeaders(resp.Trailers)
+	}
+}
+
+func printHeaders(h headers.Headers) {
+	keys := make([]string, 0, len(h))
+	for key := range h {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		fmt.Printf("%s: %s\n", key, h[key])
+	}
+}
+
+// verifyDigest checks body against the X-Content-SHA256 trailer, and the
+// X-Content-Length trailer when there is one, as the reverse proxy sends
+// them. The digest covers the body as received, without decoding its
+// Content-Encoding, since the server never compresses a response with
+// trailers on top of what the proxy hashed.
+func verifyDigest(body []byte, trailers headers.Headers) error {
+	want := trailers.Get("X-Content-SHA256")
+	if want == "" {
+		return fmt.Errorf("response has no X-Content-SHA256 trailer")
+	}
+	if got := fmt.Sprintf("%x", sha256.Sum256(body)); !strings.EqualFold(got, want) {
+		return fmt.Errorf("body hashes to %s, trailer says %s", got, want)
+	}
+	if length := trailers.Get("X-Content-Length"); length != "" {
+		if want, err := strconv.Atoi(length); err != nil || want != len(body) {
+			return fmt.Errorf("body is %d bytes, trailer says %s", len(body), length)
+		}
+	}
+	return nil
+}
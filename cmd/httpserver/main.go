@@ -1,27 +1,62 @@
 package main
 
 import (
-	"crypto/sha256"
-	"fmt"
-	"io"
 	"log"
-	"net/http"
-	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
-	"github.com/xixotron/httpfromtcp/internal/headers"
+	"github.com/xixotron/httpfromtcp/internal/fileserver"
+	"github.com/xixotron/httpfromtcp/internal/proxy"
 	"github.com/xixotron/httpfromtcp/internal/request"
 	"github.com/xixotron/httpfromtcp/internal/response"
 	"github.com/xixotron/httpfromtcp/internal/server"
+	"github.com/xixotron/httpfromtcp/internal/sse"
+	"github.com/xixotron/httpfromtcp/internal/websocket"
 )
 
 const port = 42069
 
+var assets = newAssetServer()
+
+var forwardProxy = newForwardProxy()
+
+var httpbin = newHTTPBinProxy()
+
+func newAssetServer() *fileserver.FileServer {
+	assets := fileserver.New("./assets")
+	assets.ListDirectories = true
+	return assets
+}
+
+// newForwardProxy returns the debugging proxy, which only reaches httpbin and
+// this server itself. The server listens on every interface, so any other
+// local port would be open to whoever can reach it.
+func newForwardProxy() *proxy.ForwardProxy {
+	p := proxy.NewForwardProxy()
+	p.Allow("httpbin.org", "80")
+	p.Allow("httpbin.org", "443")
+	p.Allow("localhost", strconv.Itoa(port))
+	p.Allow("127.0.0.1", strconv.Itoa(port))
+	return p
+}
+
+// newHTTPBinProxy returns the reverse proxy behind /httpbin/, which ends every
+// body with SHA-256 and length trailers.
+func newHTTPBinProxy() *proxy.ReverseProxy {
+	p, err := proxy.NewReverseProxy("https://httpbin.org")
+	if err != nil {
+		log.Fatalf("Error creating httpbin proxy: %v", err)
+	}
+	p.DigestTrailers = true
+	return p
+}
+
 func main() {
-	server, err := server.Serve(port, handlerFunc)
+	server, err := server.Serve(port, server.Conditional(server.Compress(handlerFunc)))
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
@@ -35,13 +70,22 @@ func main() {
 }
 
 func handlerFunc(w *response.Writer, req *request.Request) {
-	if req.RequestLine.RequestTarget == "/yourproblem" {
+	if req.RequestLine.Method == "CONNECT" || !strings.HasPrefix(req.RequestLine.RequestTarget, "/") {
+		forwardProxy.Serve(w, req)
+	} else if req.RequestLine.RequestTarget == "/yourproblem" {
 		handler400(w, req)
 	} else if req.RequestLine.RequestTarget == "/myproblem" {
 		handler500(w, req)
 	} else if strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin/") {
 		req.RequestLine.RequestTarget = strings.TrimPrefix(req.RequestLine.RequestTarget, "/httpbin")
-		handleHTTPProxy(w, req, "https://httpbin.org")
+		httpbin.Serve(w, req)
+	} else if strings.HasPrefix(req.RequestLine.RequestTarget, "/assets/") {
+		req.RequestLine.RequestTarget = strings.TrimPrefix(req.RequestLine.RequestTarget, "/assets")
+		assets.Serve(w, req)
+	} else if req.RequestLine.RequestTarget == "/events" {
+		handleEvents(w, req)
+	} else if req.RequestLine.RequestTarget == "/ws" {
+		handleWebSocket(w, req)
 	} else if req.RequestLine.RequestTarget == "/video" {
 		handleVideo(w, req)
 	} else {
@@ -104,109 +148,63 @@ func handler200(w *response.Writer, _ *request.Request) {
 	)
 	headers := response.GetDefaultHeaders(len(resp))
 	headers.Override("Content-Type", "text/html")
+	headers.Set("ETag", response.ContentETag([]byte(resp)))
 	w.WriteStatusLine(response.StatusOK)
 	w.WriteHeaders(headers)
 	w.WriteBody([]byte(resp))
 }
 
-func handleHTTPProxy(w *response.Writer, req *request.Request, target string) {
-	url, err := url.JoinPath(target, req.RequestLine.RequestTarget)
-	if err != nil {
-		handler400(w, req)
-		return
-	}
-
-	resp, err := http.Get(url)
+func handleEvents(w *response.Writer, req *request.Request) {
+	stream, err := sse.NewStream(w, req, 15*time.Second)
 	if err != nil {
-		log.Printf("error redirecting request: %v", err)
-		handler500(w, req)
+		log.Printf("error starting event stream: %v", err)
 		return
 	}
-	defer resp.Body.Close()
-
-	h := response.GetDefaultHeaders(0)
-	h.Override("Transfer-Encoding", "chunked")
-	h.Remove("Content-Length")
-	h.Override("Content-Type", resp.Header.Get("Content-Type"))
-	h.Set("Trailer", "X-Content-SHA256")
-	h.Set("Trailer", "X-Content-Length")
-
-	w.WriteStatusLine(response.StatusOK)
-	w.WriteHeaders(h)
+	defer stream.Close()
 
-	const chunkSize = 1024
-	buff := make([]byte, chunkSize)
-	hash := sha256.New()
-	contentLength := 0
+	// resume counting where a reconnecting client left off
+	count, _ := strconv.Atoi(stream.LastEventID())
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
 	for {
-		n, err := resp.Body.Read(buff)
-		log.Printf("Reading %v bytes from target", n)
-		if n > 0 {
-			contentLength += n
-			_, err := w.WriteChunkedBody(buff[:n])
-			hash.Write(buff[:n])
+		select {
+		case <-stream.Done():
+			return
+		case now := <-ticker.C:
+			count++
+			err := stream.Send(sse.Event{
+				ID:    strconv.Itoa(count),
+				Event: "tick",
+				Data:  now.UTC().Format(time.RFC3339),
+			})
 			if err != nil {
-				log.Printf("error writing chunked body: %v", err)
-				break
+				return
 			}
 		}
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			log.Printf("error reading response body: %v", err)
-			break
-		}
-	}
-
-	trailers := headers.NewHeaders()
-	trailers.Set("X-Content-SHA256", fmt.Sprintf("%x", hash.Sum(nil)))
-	trailers.Set("X-Content-Length", fmt.Sprintf("%d", contentLength))
-	err = w.WriteTrailers(trailers)
-	if err != nil {
-		log.Printf("error writing trailers: %v", err)
 	}
 }
 
-func handleVideo(w *response.Writer, req *request.Request) {
-	file, err := os.Open("./assets/vim.mp4")
+// handleWebSocket echoes every message back to the client.
+func handleWebSocket(w *response.Writer, req *request.Request) {
+	conn, err := websocket.Upgrade(w, req)
 	if err != nil {
-		handler500(w, req)
-		log.Printf("error reading video file: %v", err)
+		log.Printf("error upgrading to websocket: %v", err)
 		return
 	}
+	defer conn.Close(websocket.CloseNormal, "")
 
-	h := response.GetDefaultHeaders(0)
-	h.Override("Transfer-Encoding", "chunked")
-	h.Remove("Content-Length")
-	h.Override("Content-Type", "video/mp4")
-
-	w.WriteStatusLine(response.StatusOK)
-	w.WriteHeaders(h)
-
-	const chunkSize = 1024
-	buff := make([]byte, chunkSize)
 	for {
-		n, err := file.Read(buff)
-		log.Printf("Reading %v video bytes", n)
-		if n > 0 {
-			_, err := w.WriteChunkedBody(buff[:n])
-			if err != nil {
-				log.Printf("error writing chunked body: %v", err)
-				break
-			}
-		}
-		if err == io.EOF {
-			break
-		}
+		messageType, data, err := conn.ReadMessage()
 		if err != nil {
-			log.Printf("error reading response body: %v", err)
-			break
+			return
+		}
+		if err := conn.WriteMessage(messageType, data); err != nil {
+			log.Printf("error writing websocket message: %v", err)
+			return
 		}
 	}
+}
 
-	_, err = w.WriteChunkedBodyDone()
-	if err != nil {
-		log.Printf("error writing trailers: %v", err)
-	}
+func handleVideo(w *response.Writer, req *request.Request) {
+	fileserver.ServeFile(w, req, "./assets/vim.mp4")
 }
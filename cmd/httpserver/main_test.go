@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xixotron/httpfromtcp/internal/server"
+)
+
+func TestForwardProxyAllowlist(t *testing.T) {
+	local, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer local.Close()
+	s, err := server.Serve(0, newForwardProxy().Serve)
+	require.NoError(t, err)
+	defer s.Close()
+
+	connect := func(target string) string {
+		t.Helper()
+		conn, err := net.Dial("tcp", s.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = io.WriteString(conn, "CONNECT "+target+" HTTP/1.1\r\nHost: "+target+"\r\n\r\n")
+		require.NoError(t, err)
+		status, err := bufio.NewReader(conn).ReadString('\n')
+		require.NoError(t, err)
+		return status
+	}
+
+	// Test: Other services on this machine cannot be reached
+	assert.Equal(t, "HTTP/1.1 403 Forbidden\r\n", connect(local.Addr().String()))
+	assert.Equal(t, "HTTP/1.1 403 Forbidden\r\n", connect("localhost:22"))
+	assert.Equal(t, "HTTP/1.1 403 Forbidden\r\n", connect("127.0.0.1:5432"))
+}
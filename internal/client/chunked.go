@@ -0,0 +1,105 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/xixotron/httpfromtcp/internal/headers"
+)
+
+// chunkedReader decodes a chunked body, storing the trailer fields that
+// follow the last chunk in trailers.
+type chunkedReader struct {
+	r         *bufio.Reader
+	trailers  headers.Headers
+	remaining int64
+	// inChunk is set once the first chunk started, so the CRLF ending each
+	// chunk's data is expected before the next size line
+	inChunk bool
+	err     error
+}
+
+func (cr *chunkedReader) Read(p []byte) (int, error) {
+	if cr.err != nil {
+		return 0, cr.err
+	}
+	if cr.remaining == 0 {
+		if err := cr.nextChunk(); err != nil {
+			cr.err = err
+			return 0, err
+		}
+	}
+	if int64(len(p)) > cr.remaining {
+		p = p[:cr.remaining]
+	}
+	n, err := cr.r.Read(p)
+	cr.remaining -= int64(n)
+	if errors.Is(err, io.EOF) {
+		cr.err = io.ErrUnexpectedEOF
+		return n, cr.err
+	}
+	return n, err
+}
+
+// nextChunk reads up to the data of the next chunk, or through the trailers
+// after the last one, in which case it returns io.EOF.
+func (cr *chunkedReader) nextChunk() error {
+	if cr.inChunk {
+		line, err := readLine(cr.r, maxHeaderBytes)
+		if err != nil {
+			return unexpected(err)
+		}
+		if line != "" {
+			return fmt.Errorf("%w: missing CRLF after chunk data", ErrInvalidFraming)
+		}
+	}
+	line, err := readLine(cr.r, maxHeaderBytes)
+	if err != nil {
+		return unexpected(err)
+	}
+	size, err := parseChunkSize(line)
+	if err != nil {
+		return err
+	}
+	if size == 0 {
+		// Set-Cookie is not allowed in trailers and is dropped
+		trailers, _, err := readHeaders(cr.r)
+		if err != nil {
+			return unexpected(err)
+		}
+		for key, value := range trailers {
+			cr.trailers[key] = value
+		}
+		return io.EOF
+	}
+	cr.remaining = size
+	cr.inChunk = true
+	return nil
+}
+
+// parseChunkSize reads the hex size of a chunk, ignoring any extensions.
+func parseChunkSize(line string) (int64, error) {
+	size, _, _ := strings.Cut(line, ";")
+	size = strings.TrimRight(size, " \t")
+	if size == "" || strings.Trim(size, "0123456789abcdefABCDEF") != "" {
+		return 0, fmt.Errorf("%w: chunk size %q", ErrInvalidFraming, line)
+	}
+	n, err := strconv.ParseInt(size, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: chunk size %q", ErrInvalidFraming, line)
+	}
+	return n, nil
+}
+
+// unexpected turns a clean end of the connection inside a body into
+// io.ErrUnexpectedEOF, so it is not mistaken for the end of the body.
+func unexpected(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,318 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/xixotron/httpfromtcp/internal/headers"
+	"github.com/xixotron/httpfromtcp/internal/request"
+)
+
+// DefaultDialTimeout limits how long connecting to a server may take.
+const DefaultDialTimeout = 10 * time.Second
+
+// Client sends requests over HTTP/1.1 using the project's own request
+// serializer and response parser.
+type Client struct {
+	DialTimeout time.Duration
+	// Timeout limits a whole exchange, from dialing until the body is read.
+	// Zero means no limit.
+	Timeout time.Duration
+	// TLSConfig is used for https URLs. A nil config verifies the server
+	// against the system roots.
+	TLSConfig *tls.Config
+	// ResponseHeaderTimeout limits the wait for the response headers once
+	// the request was sent. Zero means no limit.
+	ResponseHeaderTimeout time.Duration
+	// Pool keeps connections open for later requests to the same host. A
+	// nil Pool sends every request on a connection of its own.
+	Pool *Pool
+	// Trace, when set, receives a copy of every byte written to and read
+	// from new connections, after TLS decryption.
+	Trace io.Writer
+}
+
+func New() *Client {
+	return &Client{
+		DialTimeout: DefaultDialTimeout,
+		Pool:        NewPool(),
+	}
+}
+
+// NewRequest builds a request for rawURL. The absolute URL is kept as the
+// request target, Do sends it in origin-form to the host it names.
+func NewRequest(method, rawURL string, body []byte) (*request.Request, error) {
+	u, err := parseURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	req := &request.Request{
+		RequestLine: request.RequestLine{
+			Method:        method,
+			RequestTarget: u.String(),
+			HttpVersion:   "1.1",
+		},
+		Headers:  headers.NewHeaders(),
+		Trailers: headers.NewHeaders(),
+		Body:     body,
+		Host:     u.Hostname(),
+		Port:     u.Port(),
+	}
+	req.Headers.Set("Host", u.Host)
+	return req, nil
+}
+
+func parseURL(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%q is not an http or https URL", rawURL)
+	}
+	return u, nil
+}
+
+// Do sends req and reads the response headers. The request target must be an
+// absolute URL, as NewRequest makes. The caller must read and close the body
+// of the response. With a Pool, a connection whose response body was read to
+// the end goes back to the pool; otherwise closing the body closes it.
+func (c *Client) Do(req *request.Request) (*Response, error) {
+	return c.DoContext(context.Background(), req)
+}
+
+// DoContext is Do with a context: cancelling ctx aborts the exchange, closing
+// its connection, including while the response body is being read.
+func (c *Client) DoContext(ctx context.Context, req *request.Request) (*Response, error) {
+	u, err := parseURL(req.RequestLine.RequestTarget)
+	if err != nil {
+		return nil, err
+	}
+	key := u.Scheme + "://" + u.Host
+	keepAlive := c.Pool != nil && !req.Headers.HasToken("Connection", "close")
+	// every step of the exchange shares the one deadline
+	var deadline time.Time
+	if c.Timeout > 0 {
+		deadline = time.Now().Add(c.Timeout)
+	}
+
+	if c.Pool == nil {
+		return c.do(ctx, req, u, key, keepAlive, deadline, nil)
+	}
+	if err := c.Pool.reserve(ctx, key, deadline); err != nil {
+		return nil, err
+	}
+	release := func() { c.Pool.release(key) }
+	resp, err := c.do(ctx, req, u, key, keepAlive, deadline, release)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return resp, nil
+}
+
+// do carries out the exchange for Do. release, when set, is called once the
+// response body is done with.
+func (c *Client) do(ctx context.Context, req *request.Request, u *url.URL, key string, keepAlive bool, deadline time.Time, release func()) (*Response, error) {
+	var pc *persistConn
+	if keepAlive {
+		pc = c.Pool.get(key)
+	}
+	if pc != nil {
+		resp, err := c.roundTrip(ctx, pc, req, u, keepAlive, deadline, release)
+		if err == nil {
+			return resp, nil
+		}
+		pc.conn.Close()
+		// the server may have closed the connection just as it was reused,
+		// before it saw the request; sending it again is only safe when that
+		// has the same effect
+		if ctx.Err() != nil || !idempotent(req.RequestLine.Method) {
+			return nil, err
+		}
+	}
+
+	conn, err := c.dial(ctx, u, deadline)
+	if err != nil {
+		return nil, err
+	}
+	if c.Trace != nil {
+		conn = &traceConn{Conn: conn, trace: c.Trace}
+	}
+	pc = &persistConn{conn: conn, br: bufio.NewReader(conn), key: key}
+	resp, err := c.roundTrip(ctx, pc, req, u, keepAlive, deadline, release)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return resp, nil
+}
+
+// roundTrip sends req on pc and reads the response headers. A zero deadline
+// means no limit.
+func (c *Client) roundTrip(ctx context.Context, pc *persistConn, req *request.Request, u *url.URL, keepAlive bool, deadline time.Time, release func()) (*Response, error) {
+	pc.conn.SetDeadline(deadline)
+	// a deadline in the past fails any read or write in progress
+	stop := context.AfterFunc(ctx, func() { pc.conn.SetDeadline(time.Unix(1, 0)) })
+	resp, err := c.exchange(pc, req, u, keepAlive, deadline)
+	if err != nil {
+		stop()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+
+	body := &connBody{r: resp.Body, pc: pc, stop: stop, release: release}
+	if keepAlive && !resp.Close {
+		body.pool = c.Pool
+	}
+	resp.Body = body
+	return resp, nil
+}
+
+// exchange writes req and reads the response headers.
+func (c *Client) exchange(pc *persistConn, req *request.Request, u *url.URL, keepAlive bool, deadline time.Time) (*Response, error) {
+	outgoing := *req
+	outgoing.RequestLine.RequestTarget = u.RequestURI()
+	outgoing.Headers = headers.NewHeaders()
+	for key, value := range req.Headers {
+		outgoing.Headers[key] = value
+	}
+	if outgoing.Headers.Get("Host") == "" {
+		outgoing.Headers.Set("Host", u.Host)
+	}
+	if !keepAlive {
+		// the connection carries this request only
+		outgoing.Headers.Override("Connection", "close")
+	}
+	if err := outgoing.Write(pc.conn); err != nil {
+		return nil, err
+	}
+
+	if c.ResponseHeaderTimeout > 0 {
+		headerDeadline := time.Now().Add(c.ResponseHeaderTimeout)
+		if !deadline.IsZero() && deadline.Before(headerDeadline) {
+			headerDeadline = deadline
+		}
+		pc.conn.SetReadDeadline(headerDeadline)
+	}
+	resp, err := ReadResponse(pc.br, req.RequestLine.Method)
+	if err != nil {
+		return nil, err
+	}
+	if c.ResponseHeaderTimeout > 0 {
+		// the body has the rest of the overall Timeout, if any
+		pc.conn.SetReadDeadline(deadline)
+	}
+	return resp, nil
+}
+
+func (c *Client) dial(ctx context.Context, u *url.URL, deadline time.Time) (net.Conn, error) {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	address := net.JoinHostPort(u.Hostname(), port)
+	dialer := &net.Dialer{Timeout: c.DialTimeout, Deadline: deadline}
+	if u.Scheme != "https" {
+		return dialer.DialContext(ctx, "tcp", address)
+	}
+
+	config := &tls.Config{}
+	if c.TLSConfig != nil {
+		config = c.TLSConfig.Clone()
+	}
+	if config.ServerName == "" {
+		config.ServerName = u.Hostname()
+	}
+	// the client only speaks HTTP/1.1
+	config.NextProtos = []string{"http/1.1"}
+	tlsDialer := &tls.Dialer{NetDialer: dialer, Config: config}
+	return tlsDialer.DialContext(ctx, "tcp", address)
+}
+
+// connBody hands the connection back to the pool once the body was read to
+// the end, and closes it when the body is closed before that.
+type connBody struct {
+	r    io.Reader
+	pc   *persistConn
+	pool *Pool
+	// stop detaches the exchange from its context; once that already
+	// fired, the connection cannot be reused
+	stop func() bool
+	// release ends the exchange for the pool's MaxConnsPerHost
+	release func()
+	once    sync.Once
+}
+
+func (b *connBody) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err == io.EOF {
+		b.once.Do(func() {
+			if b.stop() && b.pool != nil {
+				b.pool.put(b.pc)
+			} else {
+				b.pc.conn.Close()
+			}
+			b.done()
+		})
+	}
+	return n, err
+}
+
+func (b *connBody) Close() error {
+	var err error
+	b.once.Do(func() {
+		b.stop()
+		err = b.pc.conn.Close()
+		b.done()
+	})
+	return err
+}
+
+func (b *connBody) done() {
+	if b.release != nil {
+		b.release()
+	}
+}
+
+// traceConn copies the bytes going through a connection to trace.
+type traceConn struct {
+	net.Conn
+	trace io.Writer
+}
+
+func (c *traceConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.trace.Write(p[:n])
+	return n, err
+}
+
+func (c *traceConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.trace.Write(p[:n])
+	return n, err
+}
+
+// idempotent methods can be sent again when a connection fails, since
+// repeating them has the same effect as sending them once (RFC 9110
+// section 9.2.2).
+func idempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	default:
+		return false
+	}
+}
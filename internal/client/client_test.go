@@ -0,0 +1,147 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xixotron/httpfromtcp/internal/headers"
+	"github.com/xixotron/httpfromtcp/internal/request"
+	"github.com/xixotron/httpfromtcp/internal/response"
+	"github.com/xixotron/httpfromtcp/internal/server"
+)
+
+// startServer serves handler on a free port and returns its base URL.
+func startServer(t *testing.T, handler server.Handler) string {
+	t.Helper()
+	s, err := server.Serve(0, handler)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	_, port, err := net.SplitHostPort(s.Addr().String())
+	require.NoError(t, err)
+	return "http://127.0.0.1:" + port
+}
+
+func TestClientDo(t *testing.T) {
+	received := make(chan *request.Request, 1)
+	base := startServer(t, func(w *response.Writer, req *request.Request) {
+		received <- req
+		h := headers.NewHeaders()
+		h.Set("Transfer-Encoding", "chunked")
+		h.Set("Trailer", "X-Done")
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(h)
+		w.WriteChunkedBody([]byte("got "))
+		w.WriteChunkedBody(req.Body)
+		trailers := headers.NewHeaders()
+		trailers.Set("X-Done", "yes")
+		w.WriteTrailers(trailers)
+	})
+
+	// Test: Request goes out in origin-form and the response is parsed
+	req, err := NewRequest("POST", base+"/items?id=7", []byte("payload"))
+	require.NoError(t, err)
+	req.Headers.Set("X-Client", "test")
+	resp, err := New().Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "got payload", string(body))
+	assert.Equal(t, "yes", resp.Trailers.Get("X-Done"))
+
+	sent := <-received
+	assert.Equal(t, "/items?id=7", sent.RequestLine.RequestTarget)
+	assert.Equal(t, "test", sent.Headers.Get("X-Client"))
+	assert.Equal(t, "7", sent.Headers.Get("Content-Length"))
+	assert.Equal(t, "127.0.0.1", sent.Host)
+
+	// Test: Trace sees the raw exchange
+	var trace strings.Builder
+	c := New()
+	c.Trace = &trace
+	req, err = NewRequest("GET", base+"/", nil)
+	require.NoError(t, err)
+	resp, err = c.Do(req)
+	require.NoError(t, err)
+	_, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+	<-received
+	assert.True(t, strings.HasPrefix(trace.String(), "GET / HTTP/1.1\r\n"))
+	assert.Contains(t, trace.String(), "\r\n\r\nHTTP/1.1 200 OK\r\n")
+	assert.True(t, strings.HasSuffix(trace.String(), "0\r\nx-done: yes\r\n\r\n"))
+
+	// Test: Request targets must be absolute http(s) URLs
+	_, err = NewRequest("GET", "ftp://example.com/", nil)
+	require.Error(t, err)
+	req.RequestLine.RequestTarget = "/relative"
+	_, err = New().Do(req)
+	require.Error(t, err)
+
+	// Test: Unreachable server
+	req, err = NewRequest("GET", "http://127.0.0.1:1/", nil)
+	require.NoError(t, err)
+	_, err = New().Do(req)
+	require.Error(t, err)
+}
+
+func TestClientTimeout(t *testing.T) {
+	base := startServer(t, func(w *response.Writer, req *request.Request) {
+		headerDelay, _ := time.ParseDuration(req.Headers.Get("X-Header-Delay"))
+		bodyDelay, _ := time.ParseDuration(req.Headers.Get("X-Body-Delay"))
+		time.Sleep(headerDelay)
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(5))
+		w.Flush()
+		time.Sleep(bodyDelay)
+		w.WriteBody([]byte("hello"))
+	})
+	get := func(c *Client, headerDelay, bodyDelay time.Duration) error {
+		t.Helper()
+		req, err := NewRequest("GET", base+"/", nil)
+		require.NoError(t, err)
+		req.Headers.Set("X-Header-Delay", headerDelay.String())
+		req.Headers.Set("X-Body-Delay", bodyDelay.String())
+		resp, err := c.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		_, err = io.ReadAll(resp.Body)
+		return err
+	}
+
+	// Test: A slow body does not get a fresh Timeout once the headers arrived
+	c := New()
+	c.Timeout = 500 * time.Millisecond
+	c.ResponseHeaderTimeout = time.Second
+	require.NoError(t, get(c, 0, 0))
+	err := get(c, 300*time.Millisecond, 400*time.Millisecond)
+	var netErr net.Error
+	require.ErrorAs(t, err, &netErr)
+	assert.True(t, netErr.Timeout())
+
+	// Test: ResponseHeaderTimeout does not outlast Timeout
+	c.Timeout = 200 * time.Millisecond
+	c.ResponseHeaderTimeout = 10 * time.Second
+	err = get(c, 400*time.Millisecond, 0)
+	require.ErrorAs(t, err, &netErr)
+	assert.True(t, netErr.Timeout())
+
+	// Test: Cancelling the context aborts the exchange
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, err := NewRequest("GET", base+"/", nil)
+	require.NoError(t, err)
+	req.Headers.Set("X-Header-Delay", time.Second.String())
+	_, err = New().DoContext(ctx, req)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
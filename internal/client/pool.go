@@ -0,0 +1,248 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultMaxIdle        = 100
+	DefaultMaxIdlePerHost = 4
+	DefaultIdleTimeout    = 90 * time.Second
+)
+
+// ErrConnLimit is returned by Do when MaxConnsPerHost requests to the host
+// are in flight and none finished before the request's deadline.
+var ErrConnLimit = errors.New("connection limit for host reached")
+
+// Pool keeps idle keep-alive connections per host so later requests to the
+// same host can skip dialing. A Pool must not be shared between clients
+// with different TLS configurations.
+type Pool struct {
+	// MaxIdle limits the idle connections kept over all hosts, and
+	// MaxIdlePerHost those kept for any one host.
+	MaxIdle        int
+	MaxIdlePerHost int
+	// IdleTimeout is how long a connection may sit idle before it is
+	// closed instead of reused.
+	IdleTimeout time.Duration
+	// MaxConnsPerHost limits the connections in use for one host at a time,
+	// counting those being dialed. Further requests wait for one to finish,
+	// until the client's Timeout, and fail with ErrConnLimit after that.
+	// Zero means no limit.
+	MaxConnsPerHost int
+
+	mu    sync.Mutex
+	idle  map[string][]*persistConn
+	total int
+	// active counts the exchanges in flight per host; freed is closed, and
+	// replaced, whenever one of them ends
+	active map[string]int
+	freed  chan struct{}
+
+	hits   atomic.Int64
+	misses atomic.Int64
+	stale  atomic.Int64
+}
+
+// PoolStats counts how requests got their connections.
+type PoolStats struct {
+	// Hits were served over an idle connection, Misses had to dial one.
+	Hits   int64
+	Misses int64
+	// Stale connections were found closed by the server, or idle for too
+	// long, and were discarded instead of reused.
+	Stale int64
+	// Idle is the number of connections currently in the pool.
+	Idle int
+}
+
+// persistConn is a connection together with the reader its responses are
+// parsed from, which may hold bytes read ahead.
+type persistConn struct {
+	conn      net.Conn
+	br        *bufio.Reader
+	key       string
+	idleSince time.Time
+}
+
+func NewPool() *Pool {
+	return &Pool{
+		MaxIdle:        DefaultMaxIdle,
+		MaxIdlePerHost: DefaultMaxIdlePerHost,
+		IdleTimeout:    DefaultIdleTimeout,
+	}
+}
+
+// Stats returns the pool's counters.
+func (p *Pool) Stats() PoolStats {
+	p.mu.Lock()
+	idle := p.total
+	p.mu.Unlock()
+	return PoolStats{
+		Hits:   p.hits.Load(),
+		Misses: p.misses.Load(),
+		Stale:  p.stale.Load(),
+		Idle:   idle,
+	}
+}
+
+// reserve waits until fewer than MaxConnsPerHost exchanges with key are in
+// flight and counts one more, which release must end. A zero deadline waits
+// for as long as it takes, or until ctx is cancelled.
+func (p *Pool) reserve(ctx context.Context, key string, deadline time.Time) error {
+	var timeout <-chan time.Time
+	for {
+		p.mu.Lock()
+		if p.MaxConnsPerHost <= 0 || p.active[key] < p.MaxConnsPerHost {
+			if p.active == nil {
+				p.active = map[string]int{}
+			}
+			p.active[key]++
+			p.mu.Unlock()
+			return nil
+		}
+		if p.freed == nil {
+			p.freed = make(chan struct{})
+		}
+		freed := p.freed
+		p.mu.Unlock()
+
+		if timeout == nil && !deadline.IsZero() {
+			timer := time.NewTimer(time.Until(deadline))
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case <-freed:
+		case <-timeout:
+			return fmt.Errorf("%w: %d connections to %s in use", ErrConnLimit, p.MaxConnsPerHost, key)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// release ends an exchange counted by reserve, letting a waiting request go
+// ahead.
+func (p *Pool) release(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.active[key]--
+	if p.active[key] <= 0 {
+		delete(p.active, key)
+	}
+	if p.freed != nil {
+		close(p.freed)
+		p.freed = nil
+	}
+}
+
+// get returns a live idle connection for key, or nil when there is none.
+// The most recently used connection is tried first.
+func (p *Pool) get(key string) *persistConn {
+	for {
+		p.mu.Lock()
+		conns := p.idle[key]
+		if len(conns) == 0 {
+			p.mu.Unlock()
+			p.misses.Add(1)
+			return nil
+		}
+		pc := conns[len(conns)-1]
+		p.idle[key] = conns[:len(conns)-1]
+		p.total--
+		p.mu.Unlock()
+
+		if p.expired(pc, time.Now()) || !pc.alive() {
+			p.stale.Add(1)
+			pc.conn.Close()
+			continue
+		}
+		p.hits.Add(1)
+		return pc
+	}
+}
+
+// put returns pc to the pool, or closes it when the pool is full.
+func (p *Pool) put(pc *persistConn) {
+	pc.conn.SetDeadline(time.Time{})
+	pc.idleSince = time.Now()
+
+	p.mu.Lock()
+	p.pruneLocked(pc.idleSince)
+	if p.total >= p.MaxIdle || len(p.idle[pc.key]) >= p.MaxIdlePerHost {
+		p.mu.Unlock()
+		pc.conn.Close()
+		return
+	}
+	if p.idle == nil {
+		p.idle = map[string][]*persistConn{}
+	}
+	p.idle[pc.key] = append(p.idle[pc.key], pc)
+	p.total++
+	p.mu.Unlock()
+}
+
+// CloseIdle closes every idle connection.
+func (p *Pool) CloseIdle() {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.total = 0
+	p.mu.Unlock()
+	for _, conns := range idle {
+		for _, pc := range conns {
+			pc.conn.Close()
+		}
+	}
+}
+
+// pruneLocked closes the connections that were idle for too long. The
+// oldest connections come first, so each host's list is cut at the first
+// one still fresh.
+func (p *Pool) pruneLocked(now time.Time) {
+	for key, conns := range p.idle {
+		n := 0
+		for n < len(conns) && p.expired(conns[n], now) {
+			conns[n].conn.Close()
+			n++
+		}
+		if n == 0 {
+			continue
+		}
+		p.stale.Add(int64(n))
+		p.total -= n
+		if n == len(conns) {
+			delete(p.idle, key)
+		} else {
+			p.idle[key] = conns[n:]
+		}
+	}
+}
+
+func (p *Pool) expired(pc *persistConn, now time.Time) bool {
+	return p.IdleTimeout > 0 && now.Sub(pc.idleSince) > p.IdleTimeout
+}
+
+// alive checks whether the server closed the connection while it was idle.
+// The server has nothing to send between responses, so a read that does
+// not time out means the connection was closed, or carries bytes that would
+// corrupt the next response. A deadline already in the past would fail the
+// read without looking at the socket, hence the short wait.
+func (pc *persistConn) alive() bool {
+	if pc.br.Buffered() > 0 {
+		return false
+	}
+	pc.conn.SetReadDeadline(time.Now().Add(time.Millisecond))
+	_, err := pc.br.Peek(1)
+	pc.conn.SetReadDeadline(time.Time{})
+	return errors.Is(err, os.ErrDeadlineExceeded)
+}
@@ -0,0 +1,175 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xixotron/httpfromtcp/internal/request"
+	"github.com/xixotron/httpfromtcp/internal/response"
+)
+
+// get sends a GET for url and returns the body read to the end.
+func get(t *testing.T, c *Client, url string) string {
+	t.Helper()
+	req, err := NewRequest("GET", url, nil)
+	require.NoError(t, err)
+	resp, err := c.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+// startOneShotServer answers a single request on every connection, without
+// Connection: close, and then closes it.
+func startOneShotServer(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if _, err := request.NewReader(conn, request.Options{}).ReadRequest(); err != nil {
+					return
+				}
+				fmt.Fprint(conn, "HTTP/1.1 200 OK\r\nContent-Length: 4\r\n\r\nonce")
+			}()
+		}
+	}()
+	return "http://" + listener.Addr().String()
+}
+
+func TestPoolReuse(t *testing.T) {
+	base := startServer(t, func(w *response.Writer, req *request.Request) {
+		body := req.RemoteAddr
+		h := response.GetDefaultHeaders(len(body))
+		if req.RequestLine.RequestTarget != "/close" {
+			h.Override("Connection", "keep-alive")
+		}
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(h)
+		w.WriteBody([]byte(body))
+	})
+
+	// Test: A second request reuses the connection of the first
+	c := New()
+	first := get(t, c, base+"/")
+	second := get(t, c, base+"/")
+	assert.Equal(t, first, second)
+	assert.Equal(t, PoolStats{Hits: 1, Misses: 1, Idle: 1}, c.Pool.Stats())
+
+	// Test: Connection: close responses are not pooled
+	get(t, c, base+"/close")
+	assert.Equal(t, PoolStats{Hits: 2, Misses: 1, Idle: 0}, c.Pool.Stats())
+
+	// Test: Bodies closed before the end are not pooled
+	req, err := NewRequest("GET", base+"/", nil)
+	require.NoError(t, err)
+	resp, err := c.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 0, c.Pool.Stats().Idle)
+
+	// Test: No pool sends every request on a new connection
+	c.Pool = nil
+	assert.NotEqual(t, get(t, c, base+"/"), get(t, c, base+"/"))
+
+	// Test: MaxIdlePerHost limits the connections kept
+	c.Pool = NewPool()
+	c.Pool.MaxIdlePerHost = 1
+	var bodies []io.ReadCloser
+	for range 2 {
+		req, err := NewRequest("GET", base+"/", nil)
+		require.NoError(t, err)
+		resp, err := c.Do(req)
+		require.NoError(t, err)
+		bodies = append(bodies, resp.Body)
+	}
+	for _, body := range bodies {
+		_, err := io.ReadAll(body)
+		require.NoError(t, err)
+		body.Close()
+	}
+	assert.Equal(t, 1, c.Pool.Stats().Idle)
+
+	// Test: Connections idle for longer than IdleTimeout are not reused
+	c.Pool.IdleTimeout = time.Millisecond
+	time.Sleep(5 * time.Millisecond)
+	get(t, c, base+"/")
+	assert.Equal(t, PoolStats{Hits: 0, Misses: 3, Stale: 1, Idle: 1}, c.Pool.Stats())
+}
+
+func TestPoolServerClosed(t *testing.T) {
+	base := startOneShotServer(t)
+	c := New()
+
+	// Test: A connection the server closed is detected and replaced
+	assert.Equal(t, "once", get(t, c, base+"/"))
+	require.Equal(t, 1, c.Pool.Stats().Idle)
+	require.Eventually(t, func() bool {
+		c.Pool.mu.Lock()
+		defer c.Pool.mu.Unlock()
+		return !c.Pool.idle[base][0].alive()
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, "once", get(t, c, base+"/"))
+	assert.Equal(t, PoolStats{Hits: 0, Misses: 2, Stale: 1, Idle: 1}, c.Pool.Stats())
+}
+
+func TestPoolMaxConnsPerHost(t *testing.T) {
+	base := startServer(t, func(w *response.Writer, req *request.Request) {
+		body := req.RemoteAddr
+		h := response.GetDefaultHeaders(len(body))
+		h.Override("Connection", "keep-alive")
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(h)
+		w.WriteBody([]byte(body))
+	})
+	c := New()
+	c.Pool.MaxConnsPerHost = 1
+	req, err := NewRequest("GET", base+"/", nil)
+	require.NoError(t, err)
+	first, err := c.Do(req)
+	require.NoError(t, err)
+
+	// Test: A request over the limit fails once its Timeout runs out
+	c.Timeout = 50 * time.Millisecond
+	_, err = c.Do(req)
+	require.ErrorIs(t, err, ErrConnLimit)
+
+	// Test: A waiting request goes ahead on the connection freed for it
+	c.Timeout = 0
+	done := make(chan string)
+	go func() {
+		resp, err := c.Do(req)
+		if !assert.NoError(t, err) {
+			close(done)
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		done <- string(body)
+	}()
+	select {
+	case <-done:
+		t.Fatal("request did not wait for the connection in use")
+	case <-time.After(50 * time.Millisecond):
+	}
+	firstBody, err := io.ReadAll(first.Body)
+	require.NoError(t, err)
+	first.Body.Close()
+	assert.Equal(t, string(firstBody), <-done)
+	assert.Equal(t, PoolStats{Hits: 1, Misses: 1, Idle: 1}, c.Pool.Stats())
+}
@@ -0,0 +1,249 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/xixotron/httpfromtcp/internal/headers"
+)
+
+// maxHeaderBytes limits the size of the status line and headers, and of the
+// trailers, of a response.
+const maxHeaderBytes = 1 << 20
+
+var (
+	ErrMalformedStatusLine = errors.New("malformed status line")
+	ErrHeaderTooLarge      = errors.New("response header too large")
+	ErrInvalidFraming      = errors.New("invalid response framing")
+)
+
+// Response is a response read from a server. Body must be read to the end
+// and closed; Trailers is only filled in once Body returned io.EOF.
+type Response struct {
+	StatusCode int
+	Reason     string
+	Headers    headers.Headers
+	// SetCookies holds the value of every Set-Cookie field, in order. They
+	// are kept out of Headers, since a value may itself contain commas (in
+	// Expires) and joined values could not be told apart.
+	SetCookies []string
+	Body       io.ReadCloser
+	Trailers   headers.Headers
+	// ContentLength is the length of Body, or -1 when it is not known up
+	// front, as for chunked and close-delimited bodies.
+	ContentLength int64
+	// Close is set when the connection cannot carry another request after
+	// this response.
+	Close bool
+}
+
+// ReadResponse reads a response from r, the connection a request with method
+// was sent on. Interim 1xx responses other than 101 are skipped. The body is
+// framed as RFC 9112 section 6.3 describes: by the status and method, a
+// chunked Transfer-Encoding, Content-Length, or else the connection closing.
+func ReadResponse(r *bufio.Reader, method string) (*Response, error) {
+	for {
+		resp, err := readResponseHead(r)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode >= 100 && resp.StatusCode < 200 && resp.StatusCode != 101 {
+			continue
+		}
+		if err := resp.prepareBody(r, method); err != nil {
+			return nil, err
+		}
+		return resp, nil
+	}
+}
+
+func readResponseHead(r *bufio.Reader) (*Response, error) {
+	line, err := readLine(r, maxHeaderBytes)
+	if err != nil {
+		return nil, err
+	}
+	resp := &Response{Trailers: headers.NewHeaders()}
+	if err := resp.parseStatusLine(line); err != nil {
+		return nil, err
+	}
+	resp.Headers, resp.SetCookies, err = readHeaders(r)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Close {
+		// HTTP/1.0 connections only persist when the server says so
+		resp.Close = !resp.Headers.HasToken("Connection", "keep-alive")
+	}
+	if resp.Headers.HasToken("Connection", "close") {
+		resp.Close = true
+	}
+	return resp, nil
+}
+
+func (resp *Response) parseStatusLine(line string) error {
+	version, rest, ok := strings.Cut(line, " ")
+	if !ok || (version != "HTTP/1.1" && version != "HTTP/1.0") {
+		return fmt.Errorf("%w: %q", ErrMalformedStatusLine, line)
+	}
+	code, reason, _ := strings.Cut(rest, " ")
+	if len(code) != 3 || strings.Trim(code, "0123456789") != "" {
+		return fmt.Errorf("%w: %q", ErrMalformedStatusLine, line)
+	}
+	resp.StatusCode, _ = strconv.Atoi(code)
+	resp.Reason = reason
+	resp.Close = version == "HTTP/1.0"
+	return nil
+}
+
+// prepareBody sets up Body according to how the response is framed.
+func (resp *Response) prepareBody(r *bufio.Reader, method string) error {
+	resp.ContentLength = -1
+	if method == "HEAD" || resp.StatusCode < 200 || resp.StatusCode == 204 || resp.StatusCode == 304 {
+		resp.ContentLength = 0
+		resp.Body = io.NopCloser(bytes.NewReader(nil))
+		return nil
+	}
+
+	if te, ok := resp.Headers["transfer-encoding"]; ok {
+		codings := strings.Split(te, ",")
+		last, _, _ := strings.Cut(codings[len(codings)-1], ";")
+		if !strings.EqualFold(strings.TrimSpace(last), "chunked") {
+			// only the end of the connection tells where the body stops
+			resp.Close = true
+			resp.Body = io.NopCloser(r)
+			return nil
+		}
+		resp.Body = io.NopCloser(&chunkedReader{r: r, trailers: resp.Trailers})
+		return nil
+	}
+
+	if value, ok := resp.Headers["content-length"]; ok {
+		length, err := parseContentLength(value)
+		if err != nil {
+			return err
+		}
+		resp.ContentLength = length
+		resp.Body = io.NopCloser(&lengthReader{r: r, remaining: length})
+		return nil
+	}
+
+	resp.Close = true
+	resp.Body = io.NopCloser(r)
+	return nil
+}
+
+// parseContentLength accepts repeated values only when they all agree, like
+// the request parser does.
+func parseContentLength(value string) (int64, error) {
+	length := int64(-1)
+	for part := range strings.SplitSeq(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" || strings.Trim(part, "0123456789") != "" {
+			return 0, fmt.Errorf("%w: Content-Length %q", ErrInvalidFraming, value)
+		}
+		n, err := strconv.ParseInt(part, 10, 64)
+		if err != nil || (length != -1 && n != length) {
+			return 0, fmt.Errorf("%w: Content-Length %q", ErrInvalidFraming, value)
+		}
+		length = n
+	}
+	return length, nil
+}
+
+// readLine reads a CRLF terminated line of at most limit bytes and returns it
+// without the CRLF.
+func readLine(r *bufio.Reader, limit int) (string, error) {
+	var line []byte
+	for {
+		part, err := r.ReadSlice('\n')
+		line = append(line, part...)
+		if len(line) > limit {
+			return "", ErrHeaderTooLarge
+		}
+		if err == nil {
+			break
+		}
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		if errors.Is(err, io.EOF) && len(line) > 0 {
+			return "", io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return "", fmt.Errorf("line not terminated by CRLF: %q", line)
+	}
+	return string(line[:len(line)-2]), nil
+}
+
+// readHeaders reads header fields up to and including the empty line ending
+// them, and parses them with the headers package. Set-Cookie fields are
+// returned separately, one value per field line.
+func readHeaders(r *bufio.Reader) (headers.Headers, []string, error) {
+	var block []byte
+	for {
+		line, err := readLine(r, maxHeaderBytes-len(block))
+		if err != nil {
+			return nil, nil, err
+		}
+		block = append(block, line...)
+		block = append(block, "\r\n"...)
+		if line == "" {
+			break
+		}
+	}
+
+	h := headers.NewHeaders()
+	var setCookies []string
+	for len(block) > 0 {
+		n, done, err := h.Parse(block)
+		if err != nil {
+			return nil, nil, err
+		}
+		if done {
+			break
+		}
+		if n == 0 {
+			return nil, nil, fmt.Errorf("incomplete header block")
+		}
+		// every call parses one field line, so a Set-Cookie value is taken
+		// out before the next one would be joined to it
+		if value, ok := h["set-cookie"]; ok {
+			setCookies = append(setCookies, value)
+			h.Remove("Set-Cookie")
+		}
+		block = block[n:]
+	}
+	return h, setCookies, nil
+}
+
+// lengthReader reads a body of a known length, reporting a connection that
+// ends early as io.ErrUnexpectedEOF.
+type lengthReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (lr *lengthReader) Read(p []byte) (int, error) {
+	if lr.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > lr.remaining {
+		p = p[:lr.remaining]
+	}
+	n, err := lr.r.Read(p)
+	lr.remaining -= int64(n)
+	if errors.Is(err, io.EOF) && lr.remaining > 0 {
+		return n, io.ErrUnexpectedEOF
+	}
+	if err == nil && lr.remaining == 0 {
+		err = io.EOF
+	}
+	return n, err
+}
@@ -0,0 +1,132 @@
+package client
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// chunkReader hands out at most numBytesPerRead bytes per call, to exercise
+// reads that stop in the middle of a line.
+type chunkReader struct {
+	data            string
+	numBytesPerRead int
+	pos             int
+}
+
+func (cr *chunkReader) Read(p []byte) (n int, err error) {
+	if cr.pos >= len(cr.data) {
+		return 0, io.EOF
+	}
+	endIndex := min(cr.pos+cr.numBytesPerRead, len(cr.data))
+	n = copy(p, cr.data[cr.pos:endIndex])
+	cr.pos += n
+	return n, nil
+}
+
+func readResponse(t *testing.T, raw, method string) (*Response, string, error) {
+	t.Helper()
+	resp, err := ReadResponse(bufio.NewReaderSize(&chunkReader{data: raw, numBytesPerRead: 3}, 16), method)
+	if err != nil {
+		return nil, "", err
+	}
+	body, err := io.ReadAll(resp.Body)
+	return resp, string(body), err
+}
+
+func TestReadResponse(t *testing.T) {
+	// Test: Content-Length body
+	resp, body, err := readResponse(t, "HTTP/1.1 200 OK\r\nContent-Length: 5\r\nX-Test: a\r\n\r\nhelloEXTRA", "GET")
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "OK", resp.Reason)
+	assert.Equal(t, "a", resp.Headers.Get("X-Test"))
+	assert.Equal(t, int64(5), resp.ContentLength)
+	assert.False(t, resp.Close)
+	assert.Equal(t, "hello", body)
+
+	// Test: Set-Cookie fields are kept apart
+	resp, _, err = readResponse(t, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n"+
+		"Set-Cookie: a=1; Expires=Wed, 02 Jan 2030 00:00:00 GMT\r\nSet-Cookie: b=2\r\n\r\n", "GET")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a=1; Expires=Wed, 02 Jan 2030 00:00:00 GMT", "b=2"}, resp.SetCookies)
+	assert.Empty(t, resp.Headers.Get("Set-Cookie"))
+
+	// Test: Chunked body with trailers
+	resp, body, err = readResponse(t, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTrailer: X-Sum\r\n\r\n"+
+		"5;ext=1\r\nhello\r\n7\r\n, world\r\n0\r\nX-Sum: 12\r\n\r\n", "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world", body)
+	assert.Equal(t, int64(-1), resp.ContentLength)
+	assert.Equal(t, "12", resp.Trailers.Get("X-Sum"))
+
+	// Test: Close-delimited body
+	resp, body, err = readResponse(t, "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\n\r\nuntil the end", "GET")
+	require.NoError(t, err)
+	assert.True(t, resp.Close)
+	assert.Equal(t, "until the end", body)
+
+	// Test: HEAD, 204 and 304 responses have no body
+	resp, body, err = readResponse(t, "HTTP/1.1 200 OK\r\nContent-Length: 100\r\n\r\n", "HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, "", body)
+	assert.Equal(t, "100", resp.Headers.Get("Content-Length"))
+	_, body, err = readResponse(t, "HTTP/1.1 304 Not Modified\r\nETag: \"x\"\r\n\r\n", "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "", body)
+
+	// Test: Interim responses are skipped
+	resp, body, err = readResponse(t, "HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 201 Created\r\nContent-Length: 2\r\n\r\nok", "POST")
+	require.NoError(t, err)
+	assert.Equal(t, 201, resp.StatusCode)
+	assert.Equal(t, "ok", body)
+
+	// Test: Missing reason phrase
+	resp, _, err = readResponse(t, "HTTP/1.1 404\r\nContent-Length: 0\r\n\r\n", "GET")
+	require.NoError(t, err)
+	assert.Equal(t, 404, resp.StatusCode)
+	assert.Equal(t, "", resp.Reason)
+
+	// Test: Connection: close
+	resp, _, err = readResponse(t, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\nConnection: close\r\n\r\n", "GET")
+	require.NoError(t, err)
+	assert.True(t, resp.Close)
+}
+
+func TestReadResponseErrors(t *testing.T) {
+	// Test: Malformed status lines
+	for _, line := range []string{"HTTP/2 200 OK", "HTTP/1.1 20 OK", "HTTP/1.1 abc OK", "garbage"} {
+		_, _, err := readResponse(t, line+"\r\n\r\n", "GET")
+		require.ErrorIs(t, err, ErrMalformedStatusLine, line)
+	}
+
+	// Test: Conflicting Content-Length
+	_, _, err := readResponse(t, "HTTP/1.1 200 OK\r\nContent-Length: 5\r\nContent-Length: 6\r\n\r\nhello!", "GET")
+	require.ErrorIs(t, err, ErrInvalidFraming)
+
+	// Test: Body shorter than Content-Length
+	_, _, err = readResponse(t, "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nhello", "GET")
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// Test: Chunked body cut off
+	_, _, err = readResponse(t, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhel", "GET")
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	_, _, err = readResponse(t, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n", "GET")
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// Test: Invalid chunk size
+	_, _, err = readResponse(t, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n", "GET")
+	require.ErrorIs(t, err, ErrInvalidFraming)
+
+	// Test: Invalid header field
+	_, _, err = readResponse(t, "HTTP/1.1 200 OK\r\nBad Header: x\r\n\r\n", "GET")
+	require.Error(t, err)
+
+	// Test: Headers over the limit
+	_, _, err = readResponse(t, "HTTP/1.1 200 OK\r\nX-Big: "+strings.Repeat("a", maxHeaderBytes)+"\r\n\r\n", "GET")
+	require.ErrorIs(t, err, ErrHeaderTooLarge)
+}
@@ -0,0 +1,318 @@
+package fileserver
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/xixotron/httpfromtcp/internal/headers"
+	"github.com/xixotron/httpfromtcp/internal/request"
+	"github.com/xixotron/httpfromtcp/internal/response"
+)
+
+const indexFile = "index.html"
+
+// sniffLen is how many bytes are inspected to guess a Content-Type when the
+// file extension does not give one away.
+const sniffLen = 512
+
+// FileServer serves the files below a root directory. The request target is
+// used as the path relative to that root.
+type FileServer struct {
+	root string
+	// ListDirectories renders an HTML listing for directories that have no
+	// index.html, instead of answering 404.
+	ListDirectories bool
+}
+
+func New(root string) *FileServer {
+	return &FileServer{
+		root: root,
+	}
+}
+
+func (fsrv *FileServer) Serve(w *response.Writer, req *request.Request) {
+	if req.RequestLine.Method != "GET" && req.RequestLine.Method != "HEAD" {
+		h := errorHeaders("Method Not Allowed")
+		h.Set("Allow", "GET, HEAD")
+		w.WriteStatusLine(response.StatusMethodNotAllowed)
+		w.WriteHeaders(h)
+		w.WriteBody([]byte("Method Not Allowed"))
+		return
+	}
+
+	urlPath, err := cleanPath(req.RequestLine.RequestTarget)
+	if err != nil {
+		writeError(w, response.StatusBadRequest, "Bad Request")
+		return
+	}
+
+	// os.Root refuses to follow symlinks or ".." out of the root directory
+	root, err := os.OpenRoot(fsrv.root)
+	if err != nil {
+		log.Printf("error opening file server root: %v", err)
+		writeError(w, response.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	defer root.Close()
+
+	name := "."
+	if urlPath != "/" {
+		name = strings.TrimPrefix(strings.TrimSuffix(urlPath, "/"), "/")
+	}
+	file, info, err := openFile(root, name)
+	if err != nil {
+		writeOpenError(w, err)
+		return
+	}
+	defer file.Close()
+
+	if info.IsDir() {
+		if !strings.HasSuffix(urlPath, "/") {
+			redirect(w, urlPath+"/")
+			return
+		}
+		index, indexInfo, err := openFile(root, path.Join(name, indexFile))
+		if err == nil && !indexInfo.IsDir() {
+			defer index.Close()
+			serveFile(w, req, index, indexInfo)
+			return
+		}
+		if !fsrv.ListDirectories {
+			writeError(w, response.StatusNotFound, "Not Found")
+			return
+		}
+		fsrv.serveDirectory(w, req, file, urlPath)
+		return
+	}
+
+	if strings.HasSuffix(urlPath, "/") {
+		writeError(w, response.StatusNotFound, "Not Found")
+		return
+	}
+	serveFile(w, req, file, info)
+}
+
+// ServeFile serves the single file at name, with the same headers and range
+// support as FileServer.
+func ServeFile(w *response.Writer, req *request.Request, name string) {
+	file, err := os.Open(name)
+	if err != nil {
+		writeOpenError(w, err)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		writeError(w, response.StatusNotFound, "Not Found")
+		return
+	}
+	serveFile(w, req, file, info)
+}
+
+func serveFile(w *response.Writer, req *request.Request, file *os.File, info fs.FileInfo) {
+	contentType, err := detectContentType(file)
+	if err != nil {
+		log.Printf("error reading %s: %v", info.Name(), err)
+		writeError(w, response.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	size := info.Size()
+	etag := fileETag(info)
+	h := headers.NewHeaders()
+	h.Set("ETag", etag)
+	h.Set("Last-Modified", info.ModTime().UTC().Format(response.TimeFormat))
+	h.Set("Accept-Ranges", "bytes")
+	h.Set("Connection", "close")
+
+	// conditional headers are evaluated before Range, and turn any of the
+	// responses below into a 304 or 412
+	w.EnablePreconditions(req.RequestLine.Method, req.Headers)
+	ranges, err := requestedRanges(req, info, etag)
+	if errors.Is(err, ErrUnsatisfiableRange) {
+		message := "Range Not Satisfiable"
+		h.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		h.Set("Content-Type", "text/plain")
+		h.Set("Content-Length", strconv.Itoa(len(message)))
+		w.WriteStatusLine(response.StatusRangeNotSatisfiable)
+		w.WriteHeaders(h)
+		w.WriteBody([]byte(message))
+		return
+	}
+
+	statusCode := response.StatusOK
+	var body io.Reader = file
+	switch {
+	case len(ranges) == 1:
+		statusCode = response.StatusPartialContent
+		h.Set("Content-Type", contentType)
+		h.Set("Content-Range", ranges[0].contentRange(size))
+		h.Set("Content-Length", strconv.FormatInt(ranges[0].Length, 10))
+		if _, err := file.Seek(ranges[0].Start, io.SeekStart); err != nil {
+			log.Printf("error seeking %s: %v", info.Name(), err)
+			writeError(w, response.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+		body = io.LimitReader(file, ranges[0].Length)
+	case len(ranges) > 1:
+		statusCode = response.StatusPartialContent
+		boundary := newBoundary()
+		var length int64
+		body, length = multipartBody(file, ranges, size, contentType, boundary)
+		h.Set("Content-Type", "multipart/byteranges; boundary="+boundary)
+		h.Set("Content-Length", strconv.FormatInt(length, 10))
+	default:
+		h.Set("Content-Type", contentType)
+		h.Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+
+	w.WriteStatusLine(statusCode)
+	w.WriteHeaders(h)
+	if req.RequestLine.Method == "HEAD" {
+		return
+	}
+	if _, err := w.ReadFrom(body); err != nil {
+		log.Printf("error sending %s: %v", info.Name(), err)
+	}
+}
+
+// fileETag derives a strong entity tag from the modification time and size of
+// a file, which changes whenever the file is rewritten.
+func fileETag(info fs.FileInfo) string {
+	return response.StrongETag(fmt.Sprintf("%x-%x", info.ModTime().UnixNano(), info.Size()))
+}
+
+func (fsrv *FileServer) serveDirectory(w *response.Writer, req *request.Request, dir *os.File, urlPath string) {
+	entries, err := dir.ReadDir(-1)
+	if err != nil {
+		log.Printf("error listing %s: %v", urlPath, err)
+		writeError(w, response.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	var sb strings.Builder
+	title := html.EscapeString("Index of " + urlPath)
+	fmt.Fprintf(&sb, "<html>\n  <head>\n    <title>%s</title>\n  </head>\n  <body>\n    <h1>%s</h1>\n    <ul>\n", title, title)
+	if urlPath != "/" {
+		sb.WriteString("      <li><a href=\"../\">../</a></li>\n")
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() {
+			name += "/"
+		}
+		href := (&url.URL{Path: name}).EscapedPath()
+		// a name like "a:b" would otherwise be read as a URL scheme
+		if strings.Contains(strings.SplitN(href, "/", 2)[0], ":") {
+			href = "./" + href
+		}
+		fmt.Fprintf(&sb, "      <li><a href=\"%s\">%s</a></li>\n", html.EscapeString(href), html.EscapeString(name))
+	}
+	sb.WriteString("    </ul>\n  </body>\n</html>\n")
+	body := sb.String()
+
+	h := response.GetDefaultHeaders(len(body))
+	h.Override("Content-Type", "text/html; charset=utf-8")
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(h)
+	if req.RequestLine.Method == "HEAD" {
+		return
+	}
+	w.WriteBody([]byte(body))
+}
+
+// cleanPath decodes the path of a request target and resolves any "." and
+// ".." segments, so the result always starts with "/" and never climbs above
+// it. A trailing slash is kept since it tells directories from files.
+func cleanPath(target string) (string, error) {
+	rawPath, _, _ := strings.Cut(target, "?")
+	if !strings.HasPrefix(rawPath, "/") {
+		return "", fmt.Errorf("request target %q is not an absolute path", target)
+	}
+	decoded, err := url.PathUnescape(rawPath)
+	if err != nil {
+		return "", err
+	}
+	if strings.ContainsAny(decoded, "\x00\\") {
+		return "", fmt.Errorf("invalid character in path %q", decoded)
+	}
+
+	cleaned := path.Clean(decoded)
+	if strings.HasSuffix(decoded, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned, nil
+}
+
+func openFile(root *os.Root, name string) (*os.File, fs.FileInfo, error) {
+	file, err := root.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return file, info, nil
+}
+
+// detectContentType guesses the media type from the file extension, falling
+// back to sniffing the first bytes of content. The file offset is restored.
+func detectContentType(file *os.File) (string, error) {
+	if contentType := mime.TypeByExtension(path.Ext(file.Name())); contentType != "" {
+		return contentType, nil
+	}
+
+	buff := make([]byte, sniffLen)
+	n, err := io.ReadFull(file, buff)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return http.DetectContentType(buff[:n]), nil
+}
+
+func writeOpenError(w *response.Writer, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		writeError(w, response.StatusNotFound, "Not Found")
+	case errors.Is(err, fs.ErrPermission):
+		writeError(w, response.StatusForbidden, "Forbidden")
+	default:
+		// escaping the root through a symlink also ends up here
+		log.Printf("error opening file: %v", err)
+		writeError(w, response.StatusNotFound, "Not Found")
+	}
+}
+
+func redirect(w *response.Writer, location string) {
+	h := errorHeaders("Moved Permanently")
+	h.Set("Location", (&url.URL{Path: location}).EscapedPath())
+	w.WriteStatusLine(response.StatusMovedPermanently)
+	w.WriteHeaders(h)
+	w.WriteBody([]byte("Moved Permanently"))
+}
+
+func errorHeaders(message string) headers.Headers {
+	return response.GetDefaultHeaders(len(message))
+}
+
+func writeError(w *response.Writer, statusCode response.StatusCode, message string) {
+	w.WriteStatusLine(statusCode)
+	w.WriteHeaders(errorHeaders(message))
+	w.WriteBody([]byte(message))
+}
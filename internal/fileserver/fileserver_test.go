@@ -0,0 +1,116 @@
+package fileserver
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xixotron/httpfromtcp/internal/request"
+	"github.com/xixotron/httpfromtcp/internal/response"
+)
+
+func newRequest(t *testing.T, method, target string, headerLines ...string) *request.Request {
+	t.Helper()
+	raw := method + " " + target + " HTTP/1.1\r\nHost: localhost\r\n"
+	for _, line := range headerLines {
+		raw += line + "\r\n"
+	}
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(t, err)
+	return req
+}
+
+func serve(t *testing.T, fsrv *FileServer, method, target string, headerLines ...string) string {
+	t.Helper()
+	buff := &bytes.Buffer{}
+	w := response.NewWriter(buff)
+	fsrv.Serve(w, newRequest(t, method, target, headerLines...))
+	require.NoError(t, w.Flush())
+	return buff.String()
+}
+
+func TestFileServer(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "root")
+	require.NoError(t, os.Mkdir(root, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "hello.txt"), []byte("hello world\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "data"), []byte("<html><body>sniffed</body></html>"), 0o644))
+	require.NoError(t, os.Mkdir(filepath.Join(root, "site"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "site", "index.html"), []byte("<h1>index</h1>"), 0o644))
+	require.NoError(t, os.Mkdir(filepath.Join(root, "empty <dir>"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(base, "secret.txt"), []byte("secret"), 0o644))
+	require.NoError(t, os.Symlink(filepath.Join(base, "secret.txt"), filepath.Join(root, "link.txt")))
+
+	fsrv := New(root)
+
+	// Test: Plain file
+	resp := serve(t, fsrv, "GET", "/hello.txt")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, resp, "content-type: text/plain; charset=utf-8\r\n")
+	assert.Contains(t, resp, "content-length: 12\r\n")
+	assert.Contains(t, resp, "last-modified: ")
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\nhello world\n"))
+
+	// Test: HEAD has no body
+	resp = serve(t, fsrv, "HEAD", "/hello.txt")
+	assert.Contains(t, resp, "content-length: 12\r\n")
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\n"))
+
+	// Test: Content-Type sniffed without extension
+	resp = serve(t, fsrv, "GET", "/data")
+	assert.Contains(t, resp, "content-type: text/html; charset=utf-8\r\n")
+
+	// Test: Percent encoded path and query string
+	resp = serve(t, fsrv, "GET", "/hell%6F.txt?download=1")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+
+	// Test: Missing file
+	resp = serve(t, fsrv, "GET", "/nope.txt")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 404 Not Found\r\n"))
+
+	// Test: Path traversal stays inside the root
+	for _, target := range []string{"/../secret.txt", "/site/../../secret.txt", "/%2e%2e/secret.txt", "/link.txt"} {
+		resp = serve(t, fsrv, "GET", target)
+		assert.NotContains(t, resp, "secret", target)
+		assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 404 Not Found\r\n"), target)
+	}
+
+	// Test: Backslash and NUL rejected
+	resp = serve(t, fsrv, "GET", "/..%5csecret.txt")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 400 Bad Request\r\n"))
+	resp = serve(t, fsrv, "GET", "/hello.txt%00")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 400 Bad Request\r\n"))
+
+	// Test: Directory without slash redirects
+	resp = serve(t, fsrv, "GET", "/site")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 301 Moved Permanently\r\n"))
+	assert.Contains(t, resp, "location: /site/\r\n")
+
+	// Test: Directory serves index.html
+	resp = serve(t, fsrv, "GET", "/site/")
+	assert.Contains(t, resp, "content-type: text/html; charset=utf-8\r\n")
+	assert.True(t, strings.HasSuffix(resp, "<h1>index</h1>"))
+
+	// Test: Directory listing disabled
+	resp = serve(t, fsrv, "GET", "/")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 404 Not Found\r\n"))
+
+	// Test: Directory listing enabled
+	fsrv.ListDirectories = true
+	resp = serve(t, fsrv, "GET", "/")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, resp, `<a href="hello.txt">hello.txt</a>`)
+	assert.Contains(t, resp, `<a href="site/">site/</a>`)
+	assert.Contains(t, resp, `<a href="empty%20%3Cdir%3E/">empty &lt;dir&gt;/</a>`)
+	assert.NotContains(t, resp, `href="../"`)
+
+	// Test: Unsupported method
+	resp = serve(t, fsrv, "POST", "/hello.txt")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 405 Method Not Allowed\r\n"))
+	assert.Contains(t, resp, "allow: GET, HEAD\r\n")
+}
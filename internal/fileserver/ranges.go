@@ -0,0 +1,183 @@
+package fileserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xixotron/httpfromtcp/internal/request"
+	"github.com/xixotron/httpfromtcp/internal/response"
+)
+
+var (
+	// ErrInvalidRange is returned by ParseRange for a Range header it cannot
+	// parse. Such headers are ignored and the whole file is sent.
+	ErrInvalidRange = errors.New("invalid range")
+	// ErrUnsatisfiableRange is returned by ParseRange when none of the ranges
+	// overlap the file; it is answered with 416 Range Not Satisfiable.
+	ErrUnsatisfiableRange = errors.New("unsatisfiable range")
+)
+
+// ByteRange is a part of a file, starting at Start and Length bytes long.
+type ByteRange struct {
+	Start  int64
+	Length int64
+}
+
+// contentRange formats r as the value of a Content-Range header.
+func (r ByteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.Start, r.Start+r.Length-1, size)
+}
+
+// ParseRange parses a Range header value (RFC 9110 section 14.2) such as
+// "bytes=0-99,200-,-50" against a representation of size bytes. Ranges that
+// start past the end are dropped and ranges running past the end are
+// shortened; if nothing is left ErrUnsatisfiableRange is returned.
+func ParseRange(value string, size int64) ([]ByteRange, error) {
+	unit, specs, ok := strings.Cut(value, "=")
+	if !ok || !strings.EqualFold(strings.TrimSpace(unit), "bytes") {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidRange, value)
+	}
+
+	var ranges []ByteRange
+	found := false
+	for spec := range strings.SplitSeq(specs, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		found = true
+
+		first, last, ok := strings.Cut(spec, "-")
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidRange, value)
+		}
+		first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+
+		if first == "" {
+			// suffix range, the final n bytes
+			n, err := parseDigits(last)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %q", ErrInvalidRange, value)
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+			n = min(n, size)
+			ranges = append(ranges, ByteRange{Start: size - n, Length: n})
+			continue
+		}
+
+		start, err := parseDigits(first)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidRange, value)
+		}
+		end := size - 1
+		if last != "" {
+			end, err = parseDigits(last)
+			if err != nil || end < start {
+				return nil, fmt.Errorf("%w: %q", ErrInvalidRange, value)
+			}
+			end = min(end, size-1)
+		}
+		if start >= size {
+			continue
+		}
+		ranges = append(ranges, ByteRange{Start: start, Length: end - start + 1})
+	}
+
+	if !found {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidRange, value)
+	}
+	if len(ranges) == 0 {
+		return nil, ErrUnsatisfiableRange
+	}
+	return ranges, nil
+}
+
+func parseDigits(str string) (int64, error) {
+	if str == "" || strings.Trim(str, "0123456789") != "" {
+		return 0, fmt.Errorf("not a number %q", str)
+	}
+	return strconv.ParseInt(str, 10, 64)
+}
+
+// maxRanges caps how many ranges a single request may ask for, since every
+// part of a multipart response costs a seek and a set of part headers.
+const maxRanges = 32
+
+// requestedRanges returns the ranges of the file the client asked for, or
+// nil when the whole file should be sent. Range only applies to GET, is
+// dropped when If-Range no longer matches, and is ignored when it is invalid
+// or asks for more data than the file holds.
+func requestedRanges(req *request.Request, info fs.FileInfo, etag string) ([]ByteRange, error) {
+	rangeHeader := req.Headers.Get("Range")
+	if req.RequestLine.Method != "GET" || rangeHeader == "" {
+		return nil, nil
+	}
+	if ifRange := req.Headers.Get("If-Range"); ifRange != "" && !ifRangeMatches(ifRange, info, etag) {
+		return nil, nil
+	}
+
+	ranges, err := ParseRange(rangeHeader, info.Size())
+	if errors.Is(err, ErrUnsatisfiableRange) {
+		return nil, err
+	}
+	if err != nil || len(ranges) > maxRanges {
+		return nil, nil
+	}
+	var total int64
+	for _, r := range ranges {
+		total += r.Length
+	}
+	if total > info.Size() {
+		return nil, nil
+	}
+	return ranges, nil
+}
+
+// ifRangeMatches evaluates an If-Range precondition, which holds either the
+// entity tag or the Last-Modified date the client's partial copy came from.
+func ifRangeMatches(ifRange string, info fs.FileInfo, etag string) bool {
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		return response.ETagMatches(ifRange, etag)
+	}
+	date, err := time.Parse(response.TimeFormat, ifRange)
+	if err != nil {
+		return false
+	}
+	return date.Equal(info.ModTime().UTC().Truncate(time.Second))
+}
+
+func newBoundary() string {
+	buff := make([]byte, 16)
+	rand.Read(buff)
+	return hex.EncodeToString(buff)
+}
+
+// multipartBody builds a multipart/byteranges body (RFC 9110 section 14.6)
+// for ranges of file, returning it as a reader along with its total length.
+func multipartBody(file *os.File, ranges []ByteRange, size int64, contentType, boundary string) (io.Reader, int64) {
+	var readers []io.Reader
+	var length int64
+	for i, r := range ranges {
+		partHeader := fmt.Sprintf("--%s\r\nContent-Type: %s\r\nContent-Range: %s\r\n\r\n",
+			boundary, contentType, r.contentRange(size))
+		if i > 0 {
+			partHeader = "\r\n" + partHeader
+		}
+		readers = append(readers, strings.NewReader(partHeader), io.NewSectionReader(file, r.Start, r.Length))
+		length += int64(len(partHeader)) + r.Length
+	}
+	closing := fmt.Sprintf("\r\n--%s--\r\n", boundary)
+	readers = append(readers, strings.NewReader(closing))
+	length += int64(len(closing))
+	return io.MultiReader(readers...), length
+}
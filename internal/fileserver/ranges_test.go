@@ -0,0 +1,164 @@
+package fileserver
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xixotron/httpfromtcp/internal/response"
+)
+
+func TestParseRange(t *testing.T) {
+	// Test: Single range
+	ranges, err := ParseRange("bytes=0-499", 1000)
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{Start: 0, Length: 500}}, ranges)
+
+	// Test: Open ended range
+	ranges, err = ParseRange("bytes=900-", 1000)
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{Start: 900, Length: 100}}, ranges)
+
+	// Test: Suffix range
+	ranges, err = ParseRange("bytes=-100", 1000)
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{Start: 900, Length: 100}}, ranges)
+
+	// Test: Suffix longer than the file
+	ranges, err = ParseRange("bytes=-5000", 1000)
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{Start: 0, Length: 1000}}, ranges)
+
+	// Test: End past the file is shortened
+	ranges, err = ParseRange("bytes=990-2000", 1000)
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{Start: 990, Length: 10}}, ranges)
+
+	// Test: Multiple ranges with whitespace and empty elements
+	ranges, err = ParseRange("Bytes= 0-9 , ,20-29,-5", 1000)
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{0, 10}, {20, 10}, {995, 5}}, ranges)
+
+	// Test: Unsatisfiable ranges are dropped
+	ranges, err = ParseRange("bytes=0-9,5000-6000", 1000)
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{0, 10}}, ranges)
+
+	// Test: Nothing satisfiable
+	_, err = ParseRange("bytes=1000-", 1000)
+	require.ErrorIs(t, err, ErrUnsatisfiableRange)
+	_, err = ParseRange("bytes=-0", 1000)
+	require.ErrorIs(t, err, ErrUnsatisfiableRange)
+	_, err = ParseRange("bytes=-10", 0)
+	require.ErrorIs(t, err, ErrUnsatisfiableRange)
+
+	// Test: Invalid ranges
+	for _, value := range []string{"items=0-1", "bytes", "bytes=", "bytes=5", "bytes=9-5", "bytes=a-b", "bytes=+1-2", "bytes=--5"} {
+		_, err = ParseRange(value, 1000)
+		require.ErrorIs(t, err, ErrInvalidRange, value)
+	}
+}
+
+func TestFileServerRanges(t *testing.T) {
+	root := t.TempDir()
+	content := "0123456789abcdefghijklmnopqrstuvwxyz"
+	name := filepath.Join(root, "alphabet.txt")
+	require.NoError(t, os.WriteFile(name, []byte(content), 0o644))
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, os.Chtimes(name, modTime, modTime))
+	fsrv := New(root)
+
+	get := func(headerLines ...string) string {
+		return serve(t, fsrv, "GET", "/alphabet.txt", headerLines...)
+	}
+
+	// Test: Full response advertises ranges
+	resp := serve(t, fsrv, "GET", "/alphabet.txt")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, resp, "accept-ranges: bytes\r\n")
+
+	// Test: Single range
+	resp = get("Range: bytes=10-15")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 206 Partial Content\r\n"))
+	assert.Contains(t, resp, "content-range: bytes 10-15/36\r\n")
+	assert.Contains(t, resp, "content-length: 6\r\n")
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\nabcdef"))
+
+	// Test: Suffix range
+	resp = get("Range: bytes=-3")
+	assert.Contains(t, resp, "content-range: bytes 33-35/36\r\n")
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\nxyz"))
+
+	// Test: Multiple ranges
+	resp = get("Range: bytes=0-1,-2")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 206 Partial Content\r\n"))
+	headerEnd := strings.Index(resp, "\r\n\r\n")
+	boundary := resp[strings.Index(resp, "boundary=")+len("boundary=") : headerEnd]
+	boundary, _, _ = strings.Cut(boundary, "\r\n")
+	body := resp[headerEnd+4:]
+	assert.Equal(t,
+		"--"+boundary+"\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Range: bytes 0-1/36\r\n\r\n01"+
+			"\r\n--"+boundary+"\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Range: bytes 34-35/36\r\n\r\nyz"+
+			"\r\n--"+boundary+"--\r\n",
+		body,
+	)
+	assert.Contains(t, resp, "content-type: multipart/byteranges; boundary="+boundary+"\r\n")
+	assert.Contains(t, resp, "content-length: "+strconv.Itoa(len(body))+"\r\n")
+
+	// Test: Unsatisfiable range
+	resp = get("Range: bytes=100-200")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 416 Range Not Satisfiable\r\n"))
+	assert.Contains(t, resp, "content-range: bytes */36\r\n")
+
+	// Test: Invalid range is ignored
+	resp = get("Range: bytes=z-1")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(resp, content))
+
+	// Test: If-Range with matching date
+	resp = get("Range: bytes=0-0", "If-Range: "+modTime.Format(response.TimeFormat))
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 206 Partial Content\r\n"))
+
+	// Test: If-Range with stale date sends everything
+	resp = get("Range: bytes=0-0", "If-Range: "+modTime.Add(-time.Hour).Format(response.TimeFormat))
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(resp, content))
+
+	// Test: If-Range with matching entity tag
+	etag := resp[strings.Index(resp, "etag: ")+len("etag: "):]
+	etag, _, _ = strings.Cut(etag, "\r\n")
+	resp = get("Range: bytes=0-0", "If-Range: "+etag)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 206 Partial Content\r\n"))
+
+	// Test: If-Range with another entity tag sends everything
+	resp = get("Range: bytes=0-0", `If-Range: "other"`)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+
+	// Test: If-None-Match wins over Range
+	resp = get("Range: bytes=0-0", "If-None-Match: "+etag)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 304 Not Modified\r\n"))
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\n"))
+
+	// Test: If-Modified-Since on an unchanged file
+	resp = get("If-Modified-Since: " + modTime.Format(response.TimeFormat))
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 304 Not Modified\r\n"))
+	assert.Contains(t, resp, "last-modified: "+modTime.Format(response.TimeFormat)+"\r\n")
+
+	// Test: Range ignored on HEAD
+	resp = serve(t, fsrv, "HEAD", "/alphabet.txt", "Range: bytes=0-0")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, resp, "content-length: 36\r\n")
+
+	// Test: ServeFile supports ranges too
+	buff := &strings.Builder{}
+	req := newRequest(t, "GET", "/video", "Range: bytes=26-")
+	ServeFile(response.NewWriter(buff), req, name)
+	assert.True(t, strings.HasPrefix(buff.String(), "HTTP/1.1 206 Partial Content\r\n"))
+	assert.True(t, strings.HasSuffix(buff.String(), "\r\n\r\nqrstuvwxyz"))
+}
@@ -14,7 +14,31 @@ func NewHeaders() Headers {
 	return Headers{}
 }
 
+// ObsFoldMode selects how Parse treats obsolete line folding, a field line
+// continued on the next line by leading spaces or tabs (RFC 9112 section 5.2).
+type ObsFoldMode int
+
+const (
+	// ObsFoldReject fails parsing when a folded field line is found.
+	ObsFoldReject ObsFoldMode = iota
+	// ObsFoldReplace joins folded lines, replacing each fold with a single SP.
+	ObsFoldReplace
+)
+
+// ParseOptions tunes how strictly field lines are parsed. The zero value is
+// the strict behaviour recommended for servers.
+type ParseOptions struct {
+	// AllowSpaceBeforeColon accepts "Name : value" by trimming the name
+	// instead of rejecting the line.
+	AllowSpaceBeforeColon bool
+	ObsFold               ObsFoldMode
+}
+
 func (h Headers) Parse(data []byte) (n int, done bool, err error) {
+	return h.ParseWithOptions(data, ParseOptions{})
+}
+
+func (h Headers) ParseWithOptions(data []byte, opts ParseOptions) (n int, done bool, err error) {
 	idx := bytes.Index(data, []byte(crlf))
 	if idx == -1 {
 		return 0, false, nil
@@ -23,28 +47,59 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 		return len(crlf), true, nil
 	}
 
-	str := strings.TrimSpace(string(data[:idx]))
-	key, value, err := headerFromString(str)
+	line := string(data[:idx])
+	n = idx + len(crlf)
+	// the field line is only complete once we know the next line does not
+	// start with whitespace, which would make it an obs-fold continuation
+	for {
+		if n >= len(data) {
+			return 0, false, nil
+		}
+		if data[n] != ' ' && data[n] != '\t' {
+			break
+		}
+		if opts.ObsFold == ObsFoldReject {
+			return 0, false, fmt.Errorf("obsolete line folding is not allowed")
+		}
+		next := bytes.Index(data[n:], []byte(crlf))
+		if next == -1 {
+			return 0, false, nil
+		}
+		line = strings.TrimRight(line, " \t") + " " + strings.Trim(string(data[n:n+next]), " \t")
+		n += next + len(crlf)
+	}
+
+	str := strings.TrimSpace(line)
+	key, value, err := headerFromString(str, opts)
 	if err != nil {
 		return 0, false, err
 	}
 	h.Set(key, value)
 
-	return idx + len(crlf), false, nil
+	return n, false, nil
 }
 
-func headerFromString(str string) (key string, value string, err error) {
+func headerFromString(str string, opts ParseOptions) (key string, value string, err error) {
 	parts := strings.SplitN(str, ":", 2)
 	if len(parts) != 2 {
 		return "", "", fmt.Errorf("invalid header line %s\n", str)
 	}
 
 	key = parts[0]
-	if !validToken(key) {
+	if trimmed := strings.TrimRight(key, " \t"); trimmed != key {
+		if !opts.AllowSpaceBeforeColon {
+			return "", "", fmt.Errorf("whitespace between header name and colon %q", key)
+		}
+		key = trimmed
+	}
+	if !IsToken(key) {
 		return "", "", fmt.Errorf("invalid header name %s\n", key)
 	}
 
 	value = strings.TrimSpace(parts[1])
+	if !validFieldValue(value) {
+		return "", "", fmt.Errorf("invalid header value for %s", key)
+	}
 	return key, value, nil
 }
 
@@ -56,15 +111,34 @@ func (h Headers) Get(key string) string {
 	}
 	return value
 }
+
+// HasToken reports whether the comma separated list in field key contains
+// token, ignoring case, as in "Connection: keep-alive, Upgrade".
+func (h Headers) HasToken(key, token string) bool {
+	for part := range strings.SplitSeq(h.Get(key), ",") {
+		if strings.EqualFold(strings.TrimSpace(part), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// Set adds value to the field key, joining it to any previous value with a
+// comma. Cookie pairs are separated by semicolons instead, so repeated Cookie
+// fields are joined with "; " (RFC 9113 section 8.2.3).
 func (h Headers) Set(key string, value string) {
 	key = strings.ToLower(key)
 	if previous, ok := h[key]; ok {
+		separator := ","
+		if key == "cookie" {
+			separator = "; "
+		}
 		value = strings.Join(
 			[]string{
 				previous,
 				value,
 			},
-			", ",
+			separator,
 		)
 	}
 	h[key] = value
@@ -80,7 +154,31 @@ func (h Headers) Remove(key string) {
 	delete(h, key)
 }
 
-func validToken(str string) bool {
+// Validate checks every field name and value in h, so that a header built from
+// user input cannot be used to inject extra lines into a message.
+func (h Headers) Validate() error {
+	for key, value := range h {
+		if err := ValidateField(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateField checks a single field name and value the way Validate does.
+func ValidateField(name, value string) error {
+	if !IsToken(name) {
+		return fmt.Errorf("invalid header name %q", name)
+	}
+	if !validFieldValue(value) {
+		return fmt.Errorf("invalid header value for %s", name)
+	}
+	return nil
+}
+
+// IsToken reports whether str is a token (RFC 9110 section 5.6.2), the
+// syntax of field names and of many parameter names and values.
+func IsToken(str string) bool {
 	if len(str) < 1 {
 		return false
 	}
@@ -101,3 +199,35 @@ func validToken(str string) bool {
 	}
 	return true
 }
+
+// IsCookieValue reports whether str is a cookie-value (RFC 6265 section
+// 4.1.1): cookie-octets, visible ASCII except double quote, comma, semicolon
+// and backslash, optionally in double quotes.
+func IsCookieValue(str string) bool {
+	if len(str) >= 2 && str[0] == '"' && str[len(str)-1] == '"' {
+		str = str[1 : len(str)-1]
+	}
+	for i := 0; i < len(str); i++ {
+		c := str[i]
+		if c <= 0x20 || c >= 0x7f || c == '"' || c == ',' || c == ';' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+// validFieldValue reports whether str is a valid field-value as defined by
+// RFC 9110 section 5.5: visible characters, obs-text, spaces and tabs, but no
+// CR, LF, NUL or any other control character.
+func validFieldValue(str string) bool {
+	for i := 0; i < len(str); i++ {
+		c := str[i]
+		if c == '\t' || c == ' ' {
+			continue
+		}
+		if c < 0x21 || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
@@ -91,4 +91,152 @@ func TestHeaderParse(t *testing.T) {
 	assert.Equal(t, "lane-loves-go,prime-loves-zig,tj-loves-ocaml", headers["set-person"])
 	assert.Equal(t, 86, bytesConsumed)
 	assert.True(t, done)
+
+	// Test: Invalid header value with control character
+	headers = NewHeaders()
+	data = []byte("Host: local\x00host\r\n\r\n")
+	n, done, err = headers.Parse(data)
+	require.Error(t, err)
+	assert.Equal(t, 0, n)
+	assert.False(t, done)
+
+	// Test: Invalid header value with bare CR
+	headers = NewHeaders()
+	data = []byte("Host: local\rhost\r\n\r\n")
+	n, done, err = headers.Parse(data)
+	require.Error(t, err)
+	assert.Equal(t, 0, n)
+	assert.False(t, done)
+
+	// Test: Valid header value with inner tab and obs-text
+	headers = NewHeaders()
+	data = []byte("X-Name: caf\xe9\tau lait\r\n\r\n")
+	n, done, err = headers.Parse(data)
+	require.NoError(t, err)
+	assert.Equal(t, "caf\xe9\tau lait", headers["x-name"])
+	assert.Equal(t, 22, n)
+	assert.False(t, done)
+}
+
+func TestHeaderParseWhitespace(t *testing.T) {
+	// Test: Space before colon rejected in strict mode
+	headers := NewHeaders()
+	data := []byte("Host : localhost:42069\r\n\r\n")
+	n, done, err := headers.Parse(data)
+	require.Error(t, err)
+	assert.Equal(t, 0, n)
+	assert.False(t, done)
+
+	// Test: Tab before colon rejected in strict mode
+	headers = NewHeaders()
+	data = []byte("Host\t: localhost:42069\r\n\r\n")
+	n, done, err = headers.Parse(data)
+	require.Error(t, err)
+	assert.Equal(t, 0, n)
+	assert.False(t, done)
+
+	// Test: Space before colon allowed in lenient mode
+	headers = NewHeaders()
+	data = []byte("Host : localhost:42069\r\n\r\n")
+	n, done, err = headers.ParseWithOptions(data, ParseOptions{AllowSpaceBeforeColon: true})
+	require.NoError(t, err)
+	assert.Equal(t, "localhost:42069", headers["host"])
+	assert.Equal(t, 24, n)
+	assert.False(t, done)
+
+	// Test: Field line waits for the first byte of the next line
+	headers = NewHeaders()
+	data = []byte("Host: localhost:42069\r\n")
+	n, done, err = headers.Parse(data)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	assert.False(t, done)
+	assert.Empty(t, headers)
+}
+
+func TestHeaderParseObsFold(t *testing.T) {
+	// Test: Obs-fold rejected by default
+	headers := NewHeaders()
+	data := []byte("X-Long: first\r\n second\r\n\r\n")
+	n, done, err := headers.Parse(data)
+	require.Error(t, err)
+	assert.Equal(t, 0, n)
+	assert.False(t, done)
+
+	// Test: Obs-fold with tab rejected by default
+	headers = NewHeaders()
+	data = []byte("X-Long: first\r\n\tsecond\r\n\r\n")
+	n, done, err = headers.Parse(data)
+	require.Error(t, err)
+	assert.Equal(t, 0, n)
+	assert.False(t, done)
+
+	// Test: Obs-fold replaced with a single space
+	opts := ParseOptions{ObsFold: ObsFoldReplace}
+	headers = NewHeaders()
+	data = []byte("X-Long: first  \r\n \t second\r\n\tthird\r\nHost: localhost\r\n\r\n")
+	n, done, err = headers.ParseWithOptions(data, opts)
+	require.NoError(t, err)
+	assert.Equal(t, "first second third", headers["x-long"])
+	assert.Equal(t, 36, n)
+	assert.False(t, done)
+
+	// Test: Obs-fold waits for the continuation line to complete
+	headers = NewHeaders()
+	data = []byte("X-Long: first\r\n sec")
+	n, done, err = headers.ParseWithOptions(data, opts)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	assert.False(t, done)
+	assert.Empty(t, headers)
+
+	// Test: Obs-fold on an empty value
+	headers = NewHeaders()
+	data = []byte("X-Long:\r\n value\r\n\r\n")
+	n, done, err = headers.ParseWithOptions(data, opts)
+	require.NoError(t, err)
+	assert.Equal(t, "value", headers["x-long"])
+	assert.Equal(t, 17, n)
+	assert.False(t, done)
+}
+
+func TestHeadersValidate(t *testing.T) {
+	// Test: Valid headers
+	headers := Headers{"content-type": "text/plain", "x-value": "a\tb c"}
+	require.NoError(t, headers.Validate())
+
+	// Test: CRLF injection in value
+	headers = Headers{"location": "/home\r\nSet-Cookie: evil=1"}
+	require.Error(t, headers.Validate())
+
+	// Test: Bare LF in value
+	headers = Headers{"location": "/home\nX: y"}
+	require.Error(t, headers.Validate())
+
+	// Test: DEL in value
+	headers = Headers{"x-value": "a\x7fb"}
+	require.Error(t, headers.Validate())
+
+	// Test: Invalid name
+	headers = Headers{"bad name": "value"}
+	require.Error(t, headers.Validate())
+
+	// Test: Single fields are checked the same way
+	require.NoError(t, ValidateField("X-Value", "a\tb c"))
+	require.Error(t, ValidateField("X-Value", "a\r\nb"))
+	require.Error(t, ValidateField("bad name", "value"))
+}
+
+func TestIsCookieValue(t *testing.T) {
+	// Test: Cookie-octets, optionally quoted
+	assert.True(t, IsCookieValue("abc123"))
+	assert.True(t, IsCookieValue(`"dark"`))
+	assert.True(t, IsCookieValue(""))
+
+	// Test: Separators and unbalanced quotes
+	assert.False(t, IsCookieValue("x,y"))
+	assert.False(t, IsCookieValue("a b"))
+	assert.False(t, IsCookieValue("a;b"))
+	assert.False(t, IsCookieValue(`"open`))
+	assert.False(t, IsCookieValue(`a\b`))
 }
@@ -0,0 +1,271 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xixotron/httpfromtcp/internal/client"
+	"github.com/xixotron/httpfromtcp/internal/request"
+)
+
+// Defaults for passive ejection, see ReverseProxy.MaxFails.
+const (
+	DefaultMaxFails    = 3
+	DefaultFailTimeout = 30 * time.Second
+)
+
+// DefaultHealthCheckTimeout limits how long a health check may take, see
+// ReverseProxy.HealthCheckTimeout.
+const DefaultHealthCheckTimeout = 2 * time.Second
+
+// Backend is one upstream server of a ReverseProxy.
+type Backend struct {
+	url *url.URL
+
+	// healthy is the result of the last active health check
+	healthy atomic.Bool
+	active  atomic.Int64
+	// failures counts consecutive failed requests, ejectedUntil holds the
+	// UnixNano time until which the backend is skipped after too many
+	failures     atomic.Int64
+	ejectedUntil atomic.Int64
+}
+
+func newBackend(upstream string) (*Backend, error) {
+	u, err := url.Parse(upstream)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("upstream %q is not an http or https URL", upstream)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf("upstream %q must not have a query or fragment", upstream)
+	}
+	b := &Backend{url: u}
+	b.healthy.Store(true)
+	return b, nil
+}
+
+// URL returns the upstream URL of the backend.
+func (b *Backend) URL() string {
+	return b.url.String()
+}
+
+// Healthy reports whether the last active health check passed. Backends are
+// healthy until checked.
+func (b *Backend) Healthy() bool {
+	return b.healthy.Load()
+}
+
+// ActiveRequests returns the number of requests the backend is serving.
+func (b *Backend) ActiveRequests() int {
+	return int(b.active.Load())
+}
+
+// available reports whether requests may be sent to the backend at now.
+func (b *Backend) available(now time.Time) bool {
+	return b.healthy.Load() && now.UnixNano() >= b.ejectedUntil.Load()
+}
+
+// recordFailure counts a failed request, ejecting the backend for
+// failTimeout once maxFails happened in a row.
+func (b *Backend) recordFailure(maxFails int, failTimeout time.Duration) {
+	if b.failures.Add(1) < int64(maxFails) {
+		return
+	}
+	b.failures.Store(0)
+	b.ejectedUntil.Store(time.Now().Add(failTimeout).UnixNano())
+	log.Printf("ejecting backend %s for %s after %d failures", b.url.Host, failTimeout, maxFails)
+}
+
+func (b *Backend) recordSuccess() {
+	b.failures.Store(0)
+}
+
+// Strategy picks the backend for a request among the available ones, which
+// are given in the order the proxy was created with and never empty.
+type Strategy interface {
+	Pick(req *request.Request, backends []*Backend) *Backend
+}
+
+type roundRobin struct {
+	next atomic.Uint64
+}
+
+// RoundRobin sends requests to each backend in turn.
+func RoundRobin() Strategy {
+	return &roundRobin{}
+}
+
+func (rr *roundRobin) Pick(_ *request.Request, backends []*Backend) *Backend {
+	return backends[(rr.next.Add(1)-1)%uint64(len(backends))]
+}
+
+type leastConnections struct{}
+
+// LeastConnections sends each request to the backend serving the fewest
+// requests, the first one listed on a tie.
+func LeastConnections() Strategy {
+	return leastConnections{}
+}
+
+func (leastConnections) Pick(_ *request.Request, backends []*Backend) *Backend {
+	best := backends[0]
+	for _, b := range backends[1:] {
+		if b.active.Load() < best.active.Load() {
+			best = b
+		}
+	}
+	return best
+}
+
+type consistentHash struct {
+	header string
+}
+
+// ConsistentHash sends all requests with the same value of header to the
+// same backend, or all requests from the same client IP when header is empty
+// or missing from the request. It uses rendezvous hashing, so a backend
+// going away only moves the keys that were on it.
+func ConsistentHash(header string) Strategy {
+	return consistentHash{header: header}
+}
+
+func (ch consistentHash) Pick(req *request.Request, backends []*Backend) *Backend {
+	key := ""
+	if ch.header != "" {
+		key = req.Headers.Get(ch.header)
+	}
+	if key == "" {
+		key, _, _ = net.SplitHostPort(req.RemoteAddr)
+	}
+
+	var best *Backend
+	var bestScore uint64
+	for _, b := range backends {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+		h.Write([]byte(b.url.String()))
+		if score := h.Sum64(); best == nil || score > bestScore {
+			best, bestScore = b, score
+		}
+	}
+	return best
+}
+
+// pick chooses a backend for req that was not tried yet, or nil when none is
+// available.
+func (p *ReverseProxy) pick(req *request.Request, tried map[*Backend]bool) *Backend {
+	now := time.Now()
+	candidates := make([]*Backend, 0, len(p.backends))
+	for _, b := range p.backends {
+		if !tried[b] && b.available(now) {
+			candidates = append(candidates, b)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	return p.strategy.Pick(req, candidates)
+}
+
+// untried reports whether a backend not in tried is available, so a
+// response from the last one can be given up for another attempt.
+func (p *ReverseProxy) untried(tried map[*Backend]bool) bool {
+	now := time.Now()
+	for _, b := range p.backends {
+		if !tried[b] && b.available(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// StartHealthChecks requests path from every backend each interval, taking
+// backends that do not answer with a 2xx or 3xx status within
+// HealthCheckTimeout out of rotation until they do again. Call the returned
+// function to stop checking; it aborts the checks in flight.
+func (p *ReverseProxy) StartHealthChecks(path string, interval time.Duration) (stop func()) {
+	// a check still running when the next one is due would delay it
+	timeout := p.HealthCheckTimeout
+	if timeout <= 0 || timeout > interval/2 {
+		timeout = interval / 2
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			p.checkHealth(ctx, path, timeout)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func (p *ReverseProxy) checkHealth(ctx context.Context, path string, timeout time.Duration) {
+	var wg sync.WaitGroup
+	for _, b := range p.backends {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			healthy := p.probe(ctx, b, path, timeout)
+			if ctx.Err() != nil {
+				return
+			}
+			if b.healthy.Swap(healthy) != healthy {
+				log.Printf("backend %s is now healthy: %v", b.url.Host, healthy)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// probe requests path from b, giving up after timeout or once ctx is
+// cancelled.
+func (p *ReverseProxy) probe(ctx context.Context, b *Backend, path string, timeout time.Duration) bool {
+	req, err := client.NewRequest("GET", b.url.JoinPath(path).String(), nil)
+	if err != nil {
+		return false
+	}
+	probeClient := *p.Client
+	probeClient.Timeout = timeout
+	resp, err := probeClient.DoContext(ctx, req)
+	if err != nil {
+		return false
+	}
+	// reading the body to the end lets the connection be reused
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.StatusCode >= 200 && resp.StatusCode < 400
+}
+
+// idempotent methods can be sent again when a backend fails, since repeating
+// them has the same effect as sending them once (RFC 9110 section 9.2.2).
+func idempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	default:
+		return false
+	}
+}
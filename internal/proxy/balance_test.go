@@ -0,0 +1,225 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xixotron/httpfromtcp/internal/headers"
+	"github.com/xixotron/httpfromtcp/internal/request"
+	"github.com/xixotron/httpfromtcp/internal/response"
+)
+
+func testBackends(t *testing.T, upstreams ...string) []*Backend {
+	t.Helper()
+	var backends []*Backend
+	for _, upstream := range upstreams {
+		b, err := newBackend(upstream)
+		require.NoError(t, err)
+		backends = append(backends, b)
+	}
+	return backends
+}
+
+func requestFrom(remoteAddr string, headerLines ...string) *request.Request {
+	req := &request.Request{RemoteAddr: remoteAddr, Headers: headers.NewHeaders()}
+	for _, line := range headerLines {
+		key, value, _ := strings.Cut(line, ": ")
+		req.Headers.Set(key, value)
+	}
+	return req
+}
+
+// countingUpstream answers every request with its name and counts them.
+func countingUpstream(t *testing.T, name string, count *atomic.Int64) string {
+	return startUpstream(t, func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/health" {
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(response.GetDefaultHeaders(0))
+			w.WriteBody(nil)
+			return
+		}
+		count.Add(1)
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(name)))
+		w.WriteBody([]byte(name))
+	})
+}
+
+func TestStrategies(t *testing.T) {
+	backends := testBackends(t, "http://a", "http://b", "http://c")
+	req := requestFrom("192.0.2.1:5000")
+
+	// Test: Round robin cycles through the backends
+	rr := RoundRobin()
+	var picked []string
+	for range 4 {
+		picked = append(picked, rr.Pick(req, backends).url.Host)
+	}
+	assert.Equal(t, []string{"a", "b", "c", "a"}, picked)
+
+	// Test: Least connections prefers the idlest backend
+	backends[0].active.Store(3)
+	backends[1].active.Store(1)
+	backends[2].active.Store(2)
+	assert.Equal(t, backends[1], LeastConnections().Pick(req, backends))
+	backends[1].active.Store(2)
+	assert.Equal(t, backends[1], LeastConnections().Pick(req, backends))
+
+	// Test: Consistent hash sticks to one backend per key
+	ch := ConsistentHash("X-Session")
+	first := ch.Pick(requestFrom("192.0.2.1:5000", "X-Session: abc"), backends)
+	for range 10 {
+		assert.Equal(t, first, ch.Pick(requestFrom("198.51.100.7:6000", "X-Session: abc"), backends))
+	}
+
+	// Test: Removing another backend does not move the key
+	var others []*Backend
+	for _, b := range backends {
+		if b != first {
+			others = append(others, b)
+		}
+	}
+	remaining := append([]*Backend{first}, others[0])
+	assert.Equal(t, first, ch.Pick(requestFrom("192.0.2.1:5000", "X-Session: abc"), remaining))
+
+	// Test: Without the header the client IP is the key
+	byIP := ch.Pick(requestFrom("203.0.113.9:1111"), backends)
+	assert.Equal(t, byIP, ch.Pick(requestFrom("203.0.113.9:2222"), backends))
+
+	// Test: Keys spread over all backends
+	seen := map[*Backend]bool{}
+	for i := range 100 {
+		seen[ch.Pick(requestFrom("192.0.2.1:5000", "X-Session: "+strings.Repeat("k", i+1)), backends)] = true
+	}
+	assert.Len(t, seen, 3)
+}
+
+func TestLoadBalancerRetries(t *testing.T) {
+	var hits atomic.Int64
+	live := countingUpstream(t, "live", &hits)
+	dead := "http://127.0.0.1:1"
+
+	// Test: Idempotent requests move on to the next backend
+	p, err := NewLoadBalancer(RoundRobin(), dead, live)
+	require.NoError(t, err)
+	resp := roundTrip(t, p.Serve, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(resp, "live"))
+
+	// Test: Gateway errors from a backend are retried elsewhere
+	var unavailableHits atomic.Int64
+	unavailable := startUpstream(t, func(w *response.Writer, _ *request.Request) {
+		unavailableHits.Add(1)
+		body := "try later"
+		w.WriteStatusLine(response.StatusServiceUnavailable)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody([]byte(body))
+	})
+	p, err = NewLoadBalancer(RoundRobin(), unavailable, live)
+	require.NoError(t, err)
+	resp = roundTrip(t, p.Serve, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(resp, "live"))
+	assert.Equal(t, int64(1), unavailableHits.Load())
+
+	// Test: The last gateway error is relayed when nothing else is left
+	p, err = NewLoadBalancer(RoundRobin(), unavailable)
+	require.NoError(t, err)
+	resp = roundTrip(t, p.Serve, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 503 Service Unavailable\r\n"))
+	assert.True(t, strings.HasSuffix(resp, "try later"))
+
+	// Test: Other requests are not sent twice
+	p, err = NewLoadBalancer(RoundRobin(), dead, live)
+	require.NoError(t, err)
+	resp = roundTrip(t, p.Serve, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 1\r\n\r\nx")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 502 Bad Gateway\r\n"))
+
+	// Test: Repeated failures eject a backend
+	p, err = NewLoadBalancer(RoundRobin(), dead, live)
+	require.NoError(t, err)
+	p.MaxFails = 2
+	for range 4 {
+		roundTrip(t, p.Serve, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	}
+	assert.False(t, p.Backends()[0].available(time.Now()))
+	assert.True(t, p.Backends()[0].available(time.Now().Add(DefaultFailTimeout)))
+	assert.Equal(t, p.Backends()[1], p.pick(requestFrom("192.0.2.1:5000"), map[*Backend]bool{}))
+
+	// Test: Nothing left to try
+	p.Backends()[1].ejectedUntil.Store(time.Now().Add(time.Minute).UnixNano())
+	resp = roundTrip(t, p.Serve, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 503 Service Unavailable\r\n"))
+}
+
+func TestHealthChecks(t *testing.T) {
+	var goodHits, badHits atomic.Int64
+	good := countingUpstream(t, "good", &goodHits)
+	var badHealthy atomic.Bool
+	bad := startUpstream(t, func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/health" && !badHealthy.Load() {
+			w.WriteStatusLine(response.StatusServiceUnavailable)
+			w.WriteHeaders(response.GetDefaultHeaders(0))
+			w.WriteBody(nil)
+			return
+		}
+		badHits.Add(1)
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(3))
+		w.WriteBody([]byte("bad"))
+	})
+
+	p, err := NewLoadBalancer(RoundRobin(), good, bad)
+	require.NoError(t, err)
+	stop := p.StartHealthChecks("/health", 10*time.Millisecond)
+	defer stop()
+
+	// Test: Failing health checks take a backend out of rotation
+	require.Eventually(t, func() bool { return !p.Backends()[1].Healthy() }, time.Second, 5*time.Millisecond)
+	assert.True(t, p.Backends()[0].Healthy())
+	for range 4 {
+		roundTrip(t, p.Serve, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	}
+	assert.Equal(t, int64(4), goodHits.Load())
+	assert.Equal(t, int64(0), badHits.Load())
+
+	// Test: Passing again brings it back
+	badHealthy.Store(true)
+	require.Eventually(t, func() bool { return p.Backends()[1].Healthy() }, time.Second, 5*time.Millisecond)
+}
+
+func TestHealthChecksStop(t *testing.T) {
+	accepted := make(chan struct{}, 1)
+	host, port := listen(t, func(conn net.Conn) {
+		accepted <- struct{}{}
+		// never answers, until the client gives up
+		io.Copy(io.Discard, conn)
+	})
+	p, err := NewReverseProxy("http://" + net.JoinHostPort(host, port))
+	require.NoError(t, err)
+	p.HealthCheckTimeout = 10 * time.Second
+	stop := p.StartHealthChecks("/health", time.Minute)
+	<-accepted
+
+	// Test: Stopping aborts a check waiting on a hung backend
+	start := time.Now()
+	stop()
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestIdempotent(t *testing.T) {
+	// Test: Safe methods, PUT and DELETE can be retried
+	for _, method := range []string{"GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE"} {
+		assert.True(t, idempotent(method), method)
+	}
+	for _, method := range []string{"POST", "PATCH", "CONNECT"} {
+		assert.False(t, idempotent(method), method)
+	}
+}
@@ -0,0 +1,199 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xixotron/httpfromtcp/internal/headers"
+	"github.com/xixotron/httpfromtcp/internal/request"
+	"github.com/xixotron/httpfromtcp/internal/response"
+)
+
+// DefaultDialTimeout limits how long connecting to a destination may take.
+const DefaultDialTimeout = 10 * time.Second
+
+// ForwardProxy is an HTTP forward proxy. It forwards requests with an
+// absolute-form target ("GET http://host/path HTTP/1.1") and tunnels CONNECT
+// requests, but only to destinations that were explicitly allowed.
+type ForwardProxy struct {
+	rules []allowRule
+	// DialTimeout limits how long connecting to a destination may take.
+	DialTimeout time.Duration
+}
+
+type allowRule struct {
+	host string
+	port string
+}
+
+func NewForwardProxy() *ForwardProxy {
+	return &ForwardProxy{
+		DialTimeout: DefaultDialTimeout,
+	}
+}
+
+// Allow lets the proxy connect to host on port. The host may be a
+// "*.domain" wildcard matching any subdomain, and the port "*" for any port.
+func (p *ForwardProxy) Allow(host, port string) {
+	p.rules = append(p.rules, allowRule{
+		host: strings.ToLower(strings.TrimSuffix(host, ".")),
+		port: port,
+	})
+}
+
+func (p *ForwardProxy) allowed(host, port string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, rule := range p.rules {
+		if rule.port != "*" && rule.port != port {
+			continue
+		}
+		if rule.host == host {
+			return true
+		}
+		if suffix, ok := strings.CutPrefix(rule.host, "*"); ok && strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *ForwardProxy) Serve(w *response.Writer, req *request.Request) {
+	if req.RequestLine.Method == "CONNECT" {
+		p.serveConnect(w, req)
+		return
+	}
+	p.serveForward(w, req)
+}
+
+// serveConnect opens a tunnel to the authority-form target and splices bytes
+// both ways until either side closes.
+func (p *ForwardProxy) serveConnect(w *response.Writer, req *request.Request) {
+	host, port, err := net.SplitHostPort(req.RequestLine.RequestTarget)
+	if err != nil || host == "" || port == "" {
+		writeError(w, response.StatusBadRequest, "CONNECT target must be host:port")
+		return
+	}
+	upstream, ok := p.dial(w, host, port)
+	if !ok {
+		return
+	}
+	defer upstream.Close()
+
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(headers.NewHeaders())
+	client, buffered, err := w.Hijack()
+	if err != nil {
+		log.Printf("error hijacking connection for tunnel: %v", err)
+		return
+	}
+	defer client.Close()
+
+	// the client may not have waited for our 200 before sending its first bytes
+	if len(buffered) > 0 {
+		if _, err := upstream.Write(buffered); err != nil {
+			return
+		}
+	}
+	splice(client, upstream)
+}
+
+// serveForward sends a request with an absolute-form target to its origin
+// and copies the response back unchanged. The upstream connection is closed
+// after one response, so the response is relayed as raw bytes and the client
+// connection closed with it.
+func (p *ForwardProxy) serveForward(w *response.Writer, req *request.Request) {
+	target, err := url.Parse(req.RequestLine.RequestTarget)
+	if err != nil || !target.IsAbs() || target.Host == "" {
+		writeError(w, response.StatusBadRequest, "Proxy requests need an absolute URL")
+		return
+	}
+	if target.Scheme != "http" {
+		writeError(w, response.StatusBadRequest, "Only http URLs can be forwarded, use CONNECT for https")
+		return
+	}
+	port := target.Port()
+	if port == "" {
+		port = "80"
+	}
+	upstream, ok := p.dial(w, target.Hostname(), port)
+	if !ok {
+		return
+	}
+	defer upstream.Close()
+
+	outgoing := *req
+	outgoing.RequestLine.RequestTarget = target.RequestURI()
+	outgoing.Headers = headers.NewHeaders()
+	for key, value := range req.Headers {
+		outgoing.Headers[key] = value
+	}
+	removeHopHeaders(outgoing.Headers)
+	outgoing.Headers.Override("Host", target.Host)
+	outgoing.Headers.Override("Connection", "close")
+	if err := outgoing.Write(upstream); err != nil {
+		log.Printf("error forwarding request to %s: %v", target.Host, err)
+		writeError(w, response.StatusBadGateway, "Bad Gateway")
+		return
+	}
+
+	client, _, err := w.Hijack()
+	if err != nil {
+		log.Printf("error hijacking connection for proxy: %v", err)
+		return
+	}
+	defer client.Close()
+	if _, err := io.Copy(client, upstream); err != nil {
+		log.Printf("error relaying response from %s: %v", target.Host, err)
+	}
+}
+
+// dial connects to an allowed destination, answering the client itself when
+// it cannot.
+func (p *ForwardProxy) dial(w *response.Writer, host, port string) (net.Conn, bool) {
+	if !p.allowed(host, port) {
+		writeError(w, response.StatusForbidden, "Destination not allowed")
+		return nil, false
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, port), p.DialTimeout)
+	if err != nil {
+		log.Printf("error connecting to %s: %v", net.JoinHostPort(host, port), err)
+		writeError(w, response.StatusBadGateway, "Bad Gateway")
+		return nil, false
+	}
+	return conn, true
+}
+
+// splice copies between a and b in both directions. When one side stops
+// sending, the other is told so with a half close, and splice returns once
+// both directions are done.
+func splice(a, b net.Conn) {
+	var wg sync.WaitGroup
+	copyHalf := func(dst, src net.Conn) {
+		defer wg.Done()
+		_, err := io.Copy(dst, src)
+		if err != nil && !errors.Is(err, net.ErrClosed) {
+			log.Printf("error in tunnel: %v", err)
+		}
+		if tcp, ok := dst.(*net.TCPConn); ok {
+			tcp.CloseWrite()
+		} else {
+			dst.Close()
+		}
+	}
+	wg.Add(2)
+	go copyHalf(a, b)
+	go copyHalf(b, a)
+	wg.Wait()
+}
+
+func writeError(w *response.Writer, statusCode response.StatusCode, message string) {
+	w.WriteStatusLine(statusCode)
+	w.WriteHeaders(response.GetDefaultHeaders(len(message)))
+	w.WriteBody([]byte(message))
+}
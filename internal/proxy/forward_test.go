@@ -0,0 +1,155 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xixotron/httpfromtcp/internal/request"
+	"github.com/xixotron/httpfromtcp/internal/server"
+)
+
+// listen starts a TCP listener that runs serve for each connection, and
+// returns its host and port.
+func listen(t *testing.T, serve func(conn net.Conn)) (string, string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				serve(conn)
+			}()
+		}
+	}()
+	host, port, err := net.SplitHostPort(listener.Addr().String())
+	require.NoError(t, err)
+	return host, port
+}
+
+func startProxy(t *testing.T, p *ForwardProxy) net.Conn {
+	t.Helper()
+	s, err := server.Serve(0, p.Serve)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestForwardProxy(t *testing.T) {
+	received := make(chan *request.Request, 1)
+	host, port := listen(t, func(conn net.Conn) {
+		req, err := request.RequestFromReader(conn)
+		if err != nil {
+			return
+		}
+		received <- req
+		io.WriteString(conn, "HTTP/1.1 418 I'm a teapot\r\nX-Upstream: yes\r\n\r\nshort and stout")
+	})
+	p := NewForwardProxy()
+	p.Allow(host, port)
+
+	// Test: Absolute-form request is forwarded in origin-form
+	conn := startProxy(t, p)
+	_, err := io.WriteString(conn, "POST http://"+host+":"+port+"/brew?pot=1 HTTP/1.1\r\n"+
+		"Host: "+host+":"+port+"\r\nProxy-Connection: keep-alive\r\nContent-Length: 3\r\n\r\ntea")
+	require.NoError(t, err)
+	data, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 418 I'm a teapot\r\nX-Upstream: yes\r\n\r\nshort and stout", string(data))
+
+	req := <-received
+	assert.Equal(t, "/brew?pot=1", req.RequestLine.RequestTarget)
+	assert.Equal(t, "tea", string(req.Body))
+	assert.Equal(t, "close", req.Headers.Get("Connection"))
+	assert.Empty(t, req.Headers.Get("Proxy-Connection"))
+
+	// Test: Destination not in the allowlist
+	conn = startProxy(t, p)
+	_, err = io.WriteString(conn, "GET http://"+host+":1/ HTTP/1.1\r\nHost: "+host+":1\r\n\r\n")
+	require.NoError(t, err)
+	status, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 403 Forbidden\r\n", status)
+
+	// Test: Origin-form requests are not proxy requests
+	conn = startProxy(t, p)
+	_, err = io.WriteString(conn, "GET /brew HTTP/1.1\r\nHost: "+host+"\r\n\r\n")
+	require.NoError(t, err)
+	status, err = bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 400 Bad Request\r\n", status)
+}
+
+func TestConnectTunnel(t *testing.T) {
+	host, port := listen(t, func(conn net.Conn) {
+		io.Copy(conn, conn)
+	})
+	p := NewForwardProxy()
+	p.Allow("*.example.com", "443")
+	p.Allow(host, "*")
+
+	// Test: Bytes flow both ways, including those sent with the CONNECT
+	conn := startProxy(t, p)
+	_, err := io.WriteString(conn, "CONNECT "+host+":"+port+" HTTP/1.1\r\nHost: "+host+":"+port+"\r\n\r\nearly ")
+	require.NoError(t, err)
+	reader := bufio.NewReader(conn)
+	status, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", status)
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\r\n" {
+			break
+		}
+	}
+	_, err = io.WriteString(conn, "late\n")
+	require.NoError(t, err)
+	echoed, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "early late\n", echoed)
+
+	// Test: Closing our side ends the tunnel
+	conn.(*net.TCPConn).CloseWrite()
+	rest, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Empty(t, rest)
+
+	// Test: Tunnel to a port that is not allowed
+	conn = startProxy(t, NewForwardProxy())
+	_, err = io.WriteString(conn, "CONNECT "+host+":"+port+" HTTP/1.1\r\nHost: "+host+"\r\n\r\n")
+	require.NoError(t, err)
+	status, err = bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 403 Forbidden\r\n", status)
+}
+
+func TestAllowed(t *testing.T) {
+	p := NewForwardProxy()
+	p.Allow("Example.com", "80")
+	p.Allow("*.api.example.com", "*")
+
+	// Test: Exact host and port
+	assert.True(t, p.allowed("example.com", "80"))
+	assert.True(t, p.allowed("EXAMPLE.com.", "80"))
+	assert.False(t, p.allowed("example.com", "443"))
+
+	// Test: Wildcard matches subdomains only
+	assert.True(t, p.allowed("v1.api.example.com", "8443"))
+	assert.False(t, p.allowed("api.example.com", "443"))
+	assert.False(t, p.allowed("evilapi.example.com", "443"))
+	assert.False(t, p.allowed("example.org", "80"))
+}
@@ -0,0 +1,34 @@
+package proxy
+
+import (
+	"strings"
+
+	"github.com/xixotron/httpfromtcp/internal/headers"
+)
+
+// hopHeaders describe a single connection rather than the message, so a proxy
+// must not pass them on (RFC 9110 section 7.6.1).
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Connection",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"TE",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// removeHopHeaders deletes the hop-by-hop fields from h, including any the
+// Connection header names.
+func removeHopHeaders(h headers.Headers) {
+	for name := range strings.SplitSeq(h.Get("Connection"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			h.Remove(name)
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Remove(name)
+	}
+}
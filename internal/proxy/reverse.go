@@ -0,0 +1,308 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xixotron/httpfromtcp/internal/client"
+	"github.com/xixotron/httpfromtcp/internal/headers"
+	"github.com/xixotron/httpfromtcp/internal/request"
+	"github.com/xixotron/httpfromtcp/internal/response"
+)
+
+// viaPseudonym identifies this proxy in Via headers.
+const viaPseudonym = "1.1 httpfromtcp"
+
+// copyBufferSize is how much of a streamed upstream body is read, and sent
+// on as one chunk, at a time.
+const copyBufferSize = 32 * 1024
+
+// DefaultResponseHeaderTimeout limits the wait for a backend's response
+// headers.
+const DefaultResponseHeaderTimeout = 30 * time.Second
+
+func newDefaultClient() *client.Client {
+	c := client.New()
+	c.DialTimeout = DefaultDialTimeout
+	c.ResponseHeaderTimeout = DefaultResponseHeaderTimeout
+	return c
+}
+
+// ReverseProxy forwards requests to its upstream backends and relays their
+// responses. The request target is appended to the backend URL, so a backend
+// of "https://example.com/api" turns "/users?id=1" into
+// "https://example.com/api/users?id=1".
+type ReverseProxy struct {
+	backends []*Backend
+	strategy Strategy
+	// Client sends the requests upstream, keeping connections to the
+	// backends open in its pool.
+	Client *client.Client
+	// PreserveHost sends the client's Host header upstream instead of the
+	// upstream's own host name.
+	PreserveHost bool
+	// DigestTrailers streams every response body chunked and ends it with
+	// X-Content-SHA256 and X-Content-Length trailers computed over the
+	// relayed bytes: the body as the upstream sent it, still in its
+	// Content-Encoding if it had one. The Writer does not compress
+	// responses that announce trailers, so these are also the bytes the
+	// client receives once the chunked framing is removed.
+	DigestTrailers bool
+	// Retries is how many other backends an idempotent request is sent to
+	// when a backend cannot be reached or answers 502, 503 or 504.
+	Retries int
+	// MaxFails failed requests in a row eject a backend for FailTimeout.
+	// Connection errors and 502, 503 and 504 responses count as failures.
+	MaxFails    int
+	FailTimeout time.Duration
+	// HealthCheckTimeout limits each request of StartHealthChecks. It is
+	// kept to at most half the check interval.
+	HealthCheckTimeout time.Duration
+}
+
+// NewReverseProxy returns a ReverseProxy for a single upstream.
+func NewReverseProxy(upstream string) (*ReverseProxy, error) {
+	return NewLoadBalancer(RoundRobin(), upstream)
+}
+
+// NewLoadBalancer returns a ReverseProxy spreading requests over upstreams as
+// strategy decides.
+func NewLoadBalancer(strategy Strategy, upstreams ...string) (*ReverseProxy, error) {
+	if len(upstreams) == 0 {
+		return nil, fmt.Errorf("no upstreams given")
+	}
+	p := &ReverseProxy{
+		strategy:           strategy,
+		Client:             newDefaultClient(),
+		Retries:            len(upstreams) - 1,
+		MaxFails:           DefaultMaxFails,
+		FailTimeout:        DefaultFailTimeout,
+		HealthCheckTimeout: DefaultHealthCheckTimeout,
+	}
+	for _, upstream := range upstreams {
+		b, err := newBackend(upstream)
+		if err != nil {
+			return nil, err
+		}
+		p.backends = append(p.backends, b)
+	}
+	return p, nil
+}
+
+// Backends returns the upstream backends, in the order they were given.
+func (p *ReverseProxy) Backends() []*Backend {
+	return p.backends
+}
+
+func (p *ReverseProxy) Serve(w *response.Writer, req *request.Request) {
+	if !strings.HasPrefix(req.RequestLine.RequestTarget, "/") {
+		writeError(w, response.StatusBadRequest, "Bad Request")
+		return
+	}
+
+	attempts := 1
+	if idempotent(req.RequestLine.Method) {
+		attempts += p.Retries
+	}
+	tried := map[*Backend]bool{}
+	for attempt := range attempts {
+		backend := p.pick(req, tried)
+		if backend == nil {
+			break
+		}
+		tried[backend] = true
+
+		outgoing, err := p.outgoingRequest(req, backend)
+		if err != nil {
+			writeError(w, response.StatusBadRequest, "Bad Request")
+			return
+		}
+		backend.active.Add(1)
+		resp, err := p.Client.Do(outgoing)
+		if err != nil {
+			backend.active.Add(-1)
+			backend.recordFailure(p.MaxFails, p.FailTimeout)
+			log.Printf("error proxying to %s: %v", backend.url.Host, err)
+			continue
+		}
+		switch resp.StatusCode {
+		case 502, 503, 504:
+			backend.recordFailure(p.MaxFails, p.FailTimeout)
+			if attempt < attempts-1 && p.untried(tried) {
+				// draining the error page lets the connection go back to
+				// the pool
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				backend.active.Add(-1)
+				log.Printf("retrying after %d from %s", resp.StatusCode, backend.url.Host)
+				continue
+			}
+		default:
+			backend.recordSuccess()
+		}
+		p.relayResponse(w, req, resp, backend)
+		resp.Body.Close()
+		backend.active.Add(-1)
+		return
+	}
+
+	if len(tried) == 0 {
+		writeError(w, response.StatusServiceUnavailable, "No backend available")
+		return
+	}
+	writeError(w, response.StatusBadGateway, "Bad Gateway")
+}
+
+// outgoingRequest builds the upstream request: same method, headers and body,
+// without the hop-by-hop fields, and with the X-Forwarded-* and Via fields
+// describing the client.
+func (p *ReverseProxy) outgoingRequest(req *request.Request, backend *Backend) (*request.Request, error) {
+	outURL := strings.TrimSuffix(backend.url.String(), "/") + req.RequestLine.RequestTarget
+
+	outgoing, err := client.NewRequest(req.RequestLine.Method, outURL, req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	h := headers.NewHeaders()
+	for key, value := range req.Headers {
+		h[key] = value
+	}
+	removeHopHeaders(h)
+	// the body has been read in full, and is sent on with a Content-Length
+	h.Remove("Content-Length")
+	clientHost := h.Get("Host")
+	h.Remove("Host")
+
+	if ip, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		h.Set("X-Forwarded-For", ip)
+	}
+	h.Override("X-Forwarded-Proto", "http")
+	if clientHost != "" {
+		h.Override("X-Forwarded-Host", clientHost)
+	}
+	h.Set("Via", viaPseudonym)
+
+	for key, value := range h {
+		outgoing.Headers[key] = value
+	}
+	if p.PreserveHost && clientHost != "" {
+		outgoing.Headers.Override("Host", clientHost)
+	}
+	return outgoing, nil
+}
+
+// relayResponse sends the upstream status and headers on to the client,
+// followed by the body framed for the client's connection.
+func (p *ReverseProxy) relayResponse(w *response.Writer, req *request.Request, resp *client.Response, backend *Backend) {
+	h := headers.NewHeaders()
+	for key, value := range resp.Headers {
+		h[key] = value
+	}
+	var declared []string
+	for name := range strings.SplitSeq(h.Get("Trailer"), ",") {
+		// fields that may not be trailers would be refused when they
+		// arrive, so they are not announced either
+		if name = strings.TrimSpace(name); name != "" && response.TrailerAllowed(name) {
+			declared = append(declared, name)
+		}
+	}
+	removeHopHeaders(h)
+	h.Set("Via", viaPseudonym)
+	for _, cookie := range resp.SetCookies {
+		if err := w.AddSetCookie(cookie); err != nil {
+			log.Printf("dropping Set-Cookie from %s: %v", backend.url.Host, err)
+		}
+	}
+
+	hasBody := req.RequestLine.Method != "HEAD" &&
+		resp.StatusCode >= 200 && resp.StatusCode != 204 && resp.StatusCode != 304
+	if !hasBody {
+		writeStatus(w, resp)
+		w.WriteHeaders(h)
+		return
+	}
+
+	if resp.ContentLength >= 0 && len(declared) == 0 && !p.DigestTrailers {
+		h.Override("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+		writeStatus(w, resp)
+		w.WriteHeaders(h)
+		if _, err := w.ReadFrom(resp.Body); err != nil {
+			log.Printf("error relaying response from %s: %v", backend.url.Host, err)
+		}
+		return
+	}
+
+	h.Remove("Content-Length")
+	h.Override("Transfer-Encoding", "chunked")
+	for _, name := range declared {
+		h.Set("Trailer", name)
+	}
+	if p.DigestTrailers {
+		h.Set("Trailer", "X-Content-SHA256")
+		h.Set("Trailer", "X-Content-Length")
+	}
+	writeStatus(w, resp)
+	w.WriteHeaders(h)
+
+	hash := sha256.New()
+	contentLength := 0
+	buff := make([]byte, copyBufferSize)
+	for {
+		n, err := resp.Body.Read(buff)
+		if n > 0 {
+			contentLength += n
+			hash.Write(buff[:n])
+			if _, err := w.WriteChunkedBody(buff[:n]); err != nil {
+				log.Printf("error writing chunked body: %v", err)
+				return
+			}
+			// streamed upstream responses, such as event streams, must
+			// not wait for the buffer to fill
+			if err := w.Flush(); err != nil {
+				log.Printf("error writing chunked body: %v", err)
+				return
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// ending the body normally would pass a truncated response off
+			// as complete, so the connection is dropped instead
+			log.Printf("error reading response body from %s: %v", backend.url.Host, err)
+			if conn, _, err := w.Hijack(); err == nil {
+				conn.Close()
+			}
+			return
+		}
+	}
+
+	// trailers the upstream did not declare, or with invalid values, are
+	// dropped
+	for key, value := range resp.Trailers {
+		w.SetTrailer(key, value)
+	}
+	if p.DigestTrailers {
+		w.SetTrailer("X-Content-SHA256", fmt.Sprintf("%x", hash.Sum(nil)))
+		w.SetTrailer("X-Content-Length", strconv.Itoa(contentLength))
+	}
+	if _, err := w.WriteChunkedBodyDone(); err != nil {
+		log.Printf("error writing trailers: %v", err)
+	}
+}
+
+// writeStatus relays the upstream status code with its reason phrase, or with
+// the usual phrase when the upstream's cannot be sent as it is.
+func writeStatus(w *response.Writer, resp *client.Response) {
+	code := response.StatusCode(resp.StatusCode)
+	if err := w.WriteStatusLineReason(code, resp.Reason); err != nil {
+		w.WriteStatusLine(code)
+	}
+}
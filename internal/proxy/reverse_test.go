@@ -0,0 +1,201 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xixotron/httpfromtcp/internal/headers"
+	"github.com/xixotron/httpfromtcp/internal/request"
+	"github.com/xixotron/httpfromtcp/internal/response"
+	"github.com/xixotron/httpfromtcp/internal/server"
+)
+
+// startUpstream serves handler on a free port and returns its base URL.
+func startUpstream(t *testing.T, handler server.Handler) string {
+	t.Helper()
+	s, err := server.Serve(0, handler)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	_, port, err := net.SplitHostPort(s.Addr().String())
+	require.NoError(t, err)
+	return "http://127.0.0.1:" + port
+}
+
+// roundTrip sends raw through a server running handler and returns everything
+// it answers before closing the connection.
+func roundTrip(t *testing.T, handler server.Handler, raw string) string {
+	t.Helper()
+	s, err := server.Serve(0, handler)
+	require.NoError(t, err)
+	defer s.Close()
+	_, port, err := net.SplitHostPort(s.Addr().String())
+	require.NoError(t, err)
+	conn, err := net.Dial("tcp", "127.0.0.1:"+port)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, raw)
+	require.NoError(t, err)
+	data, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestReverseProxy(t *testing.T) {
+	received := make(chan *request.Request, 1)
+	upstream := startUpstream(t, func(w *response.Writer, req *request.Request) {
+		received <- req
+		body := "short and stout"
+		h := response.GetDefaultHeaders(len(body))
+		h.Override("Connection", "X-Secret")
+		h.Set("X-Secret", "hop")
+		h.Set("X-Upstream", "yes")
+		w.WriteStatusLine(418)
+		w.WriteHeaders(h)
+		w.WriteBody([]byte(body))
+	})
+	p, err := NewReverseProxy(upstream + "/api")
+	require.NoError(t, err)
+
+	// Test: Method, headers and body are forwarded and the response relayed
+	resp := roundTrip(t, p.Serve, "PUT /brew?pot=1 HTTP/1.1\r\nHost: tea.example\r\n"+
+		"Connection: close, X-Drop\r\nX-Drop: me\r\nX-Keep: me\r\nX-Forwarded-For: 10.0.0.1\r\n"+
+		"Content-Length: 3\r\n\r\ntea")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 418 I'm a teapot\r\n"))
+	assert.Contains(t, resp, "x-upstream: yes\r\n")
+	assert.Contains(t, resp, "via: 1.1 httpfromtcp\r\n")
+	assert.Contains(t, resp, "content-length: 15\r\n")
+	assert.NotContains(t, resp, "x-secret")
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\nshort and stout"))
+
+	req := <-received
+	assert.Equal(t, "PUT", req.RequestLine.Method)
+	assert.Equal(t, "/api/brew?pot=1", req.RequestLine.RequestTarget)
+	assert.Equal(t, "tea", string(req.Body))
+	assert.Equal(t, "me", req.Headers.Get("X-Keep"))
+	assert.Empty(t, req.Headers.Get("X-Drop"))
+	assert.Equal(t, "10.0.0.1,127.0.0.1", req.Headers.Get("X-Forwarded-For"))
+	assert.Equal(t, "http", req.Headers.Get("X-Forwarded-Proto"))
+	assert.Equal(t, "tea.example", req.Headers.Get("X-Forwarded-Host"))
+	assert.Equal(t, "1.1 httpfromtcp", req.Headers.Get("Via"))
+	assert.Equal(t, "127.0.0.1", req.Host)
+
+	// Test: PreserveHost keeps the client's Host
+	p.PreserveHost = true
+	roundTrip(t, p.Serve, "GET / HTTP/1.1\r\nHost: tea.example\r\nConnection: close\r\n\r\n")
+	req = <-received
+	assert.Equal(t, "tea.example", req.Host)
+	assert.Equal(t, "GET", req.RequestLine.Method)
+	assert.Empty(t, req.Body)
+
+	// Test: Unreachable upstream
+	p, err = NewReverseProxy("http://127.0.0.1:1")
+	require.NoError(t, err)
+	resp = roundTrip(t, p.Serve, "GET / HTTP/1.1\r\nHost: tea.example\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 502 Bad Gateway\r\n"))
+}
+
+func TestReverseProxyCookies(t *testing.T) {
+	upstream := startUpstream(t, func(w *response.Writer, _ *request.Request) {
+		w.SetCookie(&response.Cookie{Name: "a", Value: "1", Expires: time.Date(2030, time.January, 2, 0, 0, 0, 0, time.UTC)})
+		w.SetCookie(&response.Cookie{Name: "b", Value: "2", HttpOnly: true})
+		w.WriteStatusLineReason(response.StatusOK, "Cookies Baked")
+		w.WriteHeaders(response.GetDefaultHeaders(0))
+		w.WriteBody(nil)
+	})
+	p, err := NewReverseProxy(upstream)
+	require.NoError(t, err)
+
+	// Test: Each Set-Cookie is relayed on a line of its own, and the reason
+	// phrase as the upstream sent it
+	resp := roundTrip(t, p.Serve, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.Contains(t, resp, "\r\nset-cookie: a=1; Expires=Wed, 02 Jan 2030 00:00:00 GMT\r\n")
+	assert.Contains(t, resp, "\r\nset-cookie: b=2; HttpOnly\r\n")
+	assert.Equal(t, 2, strings.Count(resp, "set-cookie:"))
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 Cookies Baked\r\n"))
+}
+
+func TestReverseProxyTrailers(t *testing.T) {
+	body := "0123456789abcdef"
+	upstream := startUpstream(t, func(w *response.Writer, _ *request.Request) {
+		h := headers.NewHeaders()
+		h.Set("Transfer-Encoding", "chunked")
+		h.Set("Trailer", "X-Upstream-Trailer")
+		h.Set("Connection", "close")
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(h)
+		w.WriteChunkedBody([]byte(body[:8]))
+		w.WriteChunkedBody([]byte(body[8:]))
+		trailers := headers.NewHeaders()
+		trailers.Set("X-Upstream-Trailer", "done")
+		w.WriteTrailers(trailers)
+	})
+	p, err := NewReverseProxy(upstream)
+	require.NoError(t, err)
+	p.DigestTrailers = true
+
+	// Test: Upstream trailers are relayed and the digest trailers added
+	resp := roundTrip(t, p.Serve, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	head, rest, ok := strings.Cut(resp, "\r\n\r\n")
+	require.True(t, ok)
+	assert.Contains(t, head, "transfer-encoding: chunked")
+	assert.Contains(t, head+"\r\n", "trailer: X-Upstream-Trailer,X-Content-SHA256,X-Content-Length\r\n")
+	assert.NotContains(t, head, "content-length")
+
+	sum := sha256.Sum256([]byte(body))
+	decoded, err := request.RequestFromReader(strings.NewReader(
+		"POST / HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\n" + rest))
+	require.NoError(t, err)
+	assert.Equal(t, body, string(decoded.Body))
+	assert.Equal(t, "done", decoded.Trailers.Get("X-Upstream-Trailer"))
+	assert.Equal(t, fmt.Sprintf("%x", sum), decoded.Trailers.Get("X-Content-SHA256"))
+	assert.Equal(t, "16", decoded.Trailers.Get("X-Content-Length"))
+
+	// Test: HEAD responses keep their headers but get no body
+	resp = roundTrip(t, p.Serve, "HEAD / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\n"))
+}
+
+func TestReverseProxyKeepAlive(t *testing.T) {
+	upstream := startUpstream(t, func(w *response.Writer, req *request.Request) {
+		body := req.RemoteAddr
+		h := response.GetDefaultHeaders(len(body))
+		h.Override("Connection", "keep-alive")
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(h)
+		w.WriteBody([]byte(body))
+	})
+	p, err := NewReverseProxy(upstream)
+	require.NoError(t, err)
+
+	// Test: Requests to a backend share one upstream connection
+	first := roundTrip(t, p.Serve, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	second := roundTrip(t, p.Serve, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	_, firstAddr, _ := strings.Cut(first, "\r\n\r\n")
+	_, secondAddr, _ := strings.Cut(second, "\r\n\r\n")
+	assert.NotEmpty(t, firstAddr)
+	assert.Equal(t, firstAddr, secondAddr)
+	stats := p.Client.Pool.Stats()
+	assert.Equal(t, int64(1), stats.Hits)
+	assert.Equal(t, int64(1), stats.Misses)
+}
+
+func TestNewReverseProxy(t *testing.T) {
+	// Test: Upstream must be an absolute http(s) URL
+	_, err := NewReverseProxy("ftp://example.com")
+	require.Error(t, err)
+	_, err = NewReverseProxy("/relative")
+	require.Error(t, err)
+	_, err = NewReverseProxy("http://example.com/?q=1")
+	require.Error(t, err)
+	_, err = NewReverseProxy("https://example.com/base/")
+	require.NoError(t, err)
+}
@@ -0,0 +1,107 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrAmbiguousFraming is returned when a request carries both
+	// Transfer-Encoding and Content-Length, the classic request smuggling
+	// vector (RFC 9112 section 6.3).
+	ErrAmbiguousFraming = errors.New("request has both Transfer-Encoding and Content-Length")
+	// ErrInvalidContentLength is returned for a Content-Length that is not a
+	// plain decimal number, or for duplicates that disagree.
+	ErrInvalidContentLength = errors.New("invalid Content-Length")
+	// ErrInvalidTransferEncoding is returned when chunked is missing, repeated
+	// or not the final transfer coding.
+	ErrInvalidTransferEncoding = errors.New("invalid Transfer-Encoding")
+	// ErrUnsupportedTransferCoding is returned for transfer codings the parser
+	// does not implement; servers should answer 501 Not Implemented.
+	ErrUnsupportedTransferCoding = errors.New("unsupported transfer coding")
+)
+
+// prepareBody decides how the message body is framed once all headers have
+// been read, and moves the parser to the matching state.
+func (r *Request) prepareBody() error {
+	transferEncoding, hasTransferEncoding := r.Headers["transfer-encoding"]
+	contentLength, hasContentLength := r.Headers["content-length"]
+
+	if hasTransferEncoding && hasContentLength {
+		return ErrAmbiguousFraming
+	}
+
+	if hasTransferEncoding {
+		if err := validateTransferEncoding(transferEncoding); err != nil {
+			return err
+		}
+		r.state = requestStateParsingChunkSize
+		return nil
+	}
+
+	if hasContentLength {
+		length, err := parseContentLength(contentLength)
+		if err != nil {
+			return err
+		}
+		if length > 0 {
+			r.bodyLength = length
+			r.state = requestStateParsingBody
+			return nil
+		}
+	}
+
+	r.state = requestStateDone
+	return nil
+}
+
+// parseContentLength accepts only 1*DIGIT values. Repeated Content-Length
+// fields end up comma separated, and are only allowed when they all agree.
+func parseContentLength(value string) (int, error) {
+	length := -1
+	for part := range strings.SplitSeq(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" || strings.Trim(part, "0123456789") != "" {
+			return 0, fmt.Errorf("%w: %q", ErrInvalidContentLength, value)
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %q", ErrInvalidContentLength, value)
+		}
+		if length != -1 && n != length {
+			return 0, fmt.Errorf("%w: conflicting values %q", ErrInvalidContentLength, value)
+		}
+		length = n
+	}
+	return length, nil
+}
+
+func validateTransferEncoding(value string) error {
+	codings := strings.Split(value, ",")
+	for i, coding := range codings {
+		coding, _, _ = strings.Cut(coding, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "chunked" {
+			return fmt.Errorf("%w: %q", ErrUnsupportedTransferCoding, coding)
+		}
+		if i != len(codings)-1 {
+			return fmt.Errorf("%w: chunked must be applied only once, as the final coding", ErrInvalidTransferEncoding)
+		}
+	}
+	return nil
+}
+
+func parseChunkSize(line string) (int, error) {
+	size, _, _ := strings.Cut(line, ";")
+	size = strings.TrimRight(size, " \t")
+	if size == "" || strings.Trim(size, "0123456789abcdefABCDEF") != "" {
+		return 0, fmt.Errorf("error: invalid chunk size %q", line)
+	}
+	n, err := strconv.ParseInt(size, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("error: invalid chunk size %q", line)
+	}
+	return int(n), nil
+}
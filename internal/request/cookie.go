@@ -0,0 +1,49 @@
+package request
+
+import (
+	"strings"
+
+	"github.com/xixotron/httpfromtcp/internal/headers"
+)
+
+// Cookie is a name=value pair from the Cookie header of a request.
+type Cookie struct {
+	Name  string
+	Value string
+}
+
+// Cookies parses the Cookie header (RFC 6265 section 5.4) in the order the
+// pairs appear. Parsing is lenient, like browsers are when they store
+// cookies: a pair with an invalid name or value is skipped whole and the rest
+// kept. Repeated Cookie headers, merged with semicolons, are parsed as one.
+func (r *Request) Cookies() []Cookie {
+	value := r.Headers.Get("Cookie")
+	var cookies []Cookie
+	for pair := range strings.SplitSeq(value, ";") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			continue
+		}
+		name = strings.TrimSpace(name)
+		value = strings.TrimSpace(value)
+		if !headers.IsToken(name) || !headers.IsCookieValue(value) {
+			continue
+		}
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = value[1 : len(value)-1]
+		}
+		cookies = append(cookies, Cookie{Name: name, Value: value})
+	}
+	return cookies
+}
+
+// Cookie returns the value of the first cookie called name. Cookie names are
+// case-sensitive.
+func (r *Request) Cookie(name string) (string, bool) {
+	for _, c := range r.Cookies() {
+		if c.Name == name {
+			return c.Value, true
+		}
+	}
+	return "", false
+}
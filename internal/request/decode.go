@@ -0,0 +1,87 @@
+package request
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrUnsupportedContentEncoding is returned by DecodeBody for content
+	// codings it cannot undo; servers should answer 415.
+	ErrUnsupportedContentEncoding = errors.New("unsupported content coding")
+	// ErrBodyTooLarge is returned by DecodeBody when the decoded body would
+	// exceed the allowed size.
+	ErrBodyTooLarge = errors.New("decoded body too large")
+)
+
+// DecodeBody undoes the gzip and deflate content codings listed in the
+// Content-Encoding header, replacing Body with the decoded bytes and updating
+// the headers to match. Decoding stops with ErrBodyTooLarge as soon as the
+// output grows past maxSize, so a small compressed upload cannot expand into
+// an unbounded amount of memory.
+func (r *Request) DecodeBody(maxSize int) error {
+	contentEncoding := r.Headers.Get("Content-Encoding")
+	if contentEncoding == "" {
+		return nil
+	}
+
+	var codings []string
+	for coding := range strings.SplitSeq(contentEncoding, ",") {
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		switch coding {
+		case "identity", "":
+			continue
+		case "gzip", "x-gzip", "deflate":
+			codings = append(codings, coding)
+		default:
+			return fmt.Errorf("%w: %q", ErrUnsupportedContentEncoding, coding)
+		}
+	}
+
+	body := r.Body
+	// codings are listed in the order they were applied, so they are undone
+	// from last to first
+	for i := len(codings) - 1; i >= 0; i-- {
+		decoded, err := decodeCoding(codings[i], body, maxSize)
+		if err != nil {
+			return err
+		}
+		body = decoded
+	}
+
+	r.Body = body
+	r.Headers.Remove("Content-Encoding")
+	r.Headers.Override("Content-Length", strconv.Itoa(len(body)))
+	return nil
+}
+
+func decodeCoding(coding string, body []byte, maxSize int) ([]byte, error) {
+	var decoder io.ReadCloser
+	var err error
+	switch coding {
+	case "gzip", "x-gzip":
+		decoder, err = gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		decoder, err = zlib.NewReader(bytes.NewReader(body))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error: decoding %s body: %w", coding, err)
+	}
+	defer decoder.Close()
+
+	// read one byte past the limit to tell "exactly maxSize" from "too large"
+	decoded, err := io.ReadAll(io.LimitReader(decoder, int64(maxSize)+1))
+	if err != nil {
+		return nil, fmt.Errorf("error: decoding %s body: %w", coding, err)
+	}
+	if len(decoded) > maxSize {
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrBodyTooLarge, maxSize)
+	}
+	return decoded, nil
+}
@@ -0,0 +1,83 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+var (
+	// ErrMissingHost is returned for an HTTP/1.1 request without a Host
+	// header (RFC 9112 section 3.2).
+	ErrMissingHost = errors.New("missing Host header")
+	// ErrInvalidHost is returned when Host is repeated or is not a valid
+	// uri-host with an optional port.
+	ErrInvalidHost = errors.New("invalid Host header")
+)
+
+// parseHost validates the Host header and stores its normalized host name and
+// port on the request.
+func (r *Request) parseHost() error {
+	value, ok := r.Headers["host"]
+	if !ok {
+		return ErrMissingHost
+	}
+	// repeated Host fields are merged with commas, which a valid host can
+	// never contain
+	if strings.Contains(value, ",") {
+		return fmt.Errorf("%w: more than one Host %q", ErrInvalidHost, value)
+	}
+
+	host, port, err := splitHostPort(value)
+	if err != nil {
+		return err
+	}
+	r.Host = host
+	r.Port = port
+	return nil
+}
+
+// splitHostPort splits a Host value into a lower case host name without any
+// trailing dot, and a port, which may be empty.
+func splitHostPort(value string) (host string, port string, err error) {
+	host = value
+	if i := strings.LastIndexByte(value, ':'); i != -1 && !strings.HasSuffix(value, "]") {
+		host, port = value[:i], value[i+1:]
+		if strings.Trim(port, "0123456789") != "" || len(port) > 5 {
+			return "", "", fmt.Errorf("%w: bad port %q", ErrInvalidHost, value)
+		}
+	}
+
+	if strings.HasPrefix(host, "[") {
+		if !strings.HasSuffix(host, "]") {
+			return "", "", fmt.Errorf("%w: %q", ErrInvalidHost, value)
+		}
+		ip := net.ParseIP(host[1 : len(host)-1])
+		if ip == nil || ip.To4() != nil {
+			return "", "", fmt.Errorf("%w: bad IPv6 literal %q", ErrInvalidHost, value)
+		}
+		return "[" + ip.String() + "]", port, nil
+	}
+
+	if !validRegName(host) {
+		return "", "", fmt.Errorf("%w: %q", ErrInvalidHost, value)
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	return host, port, nil
+}
+
+// validRegName accepts the reg-name and IPv4address forms of RFC 3986, minus
+// the comma which is reserved for detecting repeated fields.
+func validRegName(host string) bool {
+	for _, c := range host {
+		if (c >= 'A' && c <= 'Z') ||
+			(c >= 'a' && c <= 'z') ||
+			(c >= '0' && c <= '9') ||
+			strings.ContainsRune("-._~%!$&'()*+;=", c) {
+			continue
+		}
+		return false
+	}
+	return true
+}
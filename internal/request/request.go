@@ -5,7 +5,6 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"strconv"
 	"strings"
 
 	"github.com/xixotron/httpfromtcp/internal/headers"
@@ -17,14 +16,33 @@ const (
 	requestStateInitialized requestState = iota
 	requestStateParsingHeaders
 	requestStateParsingBody
+	requestStateParsingChunkSize
+	requestStateParsingChunkData
+	requestStateParsingTrailers
 	requestStateDone
 )
 
 type Request struct {
 	RequestLine RequestLine
 	state       requestState
+	options     Options
 	Headers     headers.Headers
 	Body        []byte
+	// Trailers holds the trailer fields sent after a chunked body.
+	Trailers headers.Headers
+	// Host and Port are the normalized values of the Host header.
+	Host string
+	Port string
+	// RemoteAddr is the address of the client, filled in by the server.
+	RemoteAddr string
+
+	bodyLength     int
+	chunkRemaining int
+}
+
+// Options controls how leniently a request is parsed.
+type Options struct {
+	Headers headers.ParseOptions
 }
 
 type RequestLine struct {
@@ -38,41 +56,86 @@ const crlf = "\r\n"
 const bufferSize = 8
 
 func RequestFromReader(r io.Reader) (*Request, error) {
-	buff := make([]byte, bufferSize)
-	readToIndex := 0
+	return RequestFromReaderWithOptions(r, Options{})
+}
+
+// RequestFromReaderWithOptions reads a single request from r. Since nothing
+// else is expected after it, data following a Content-Length body is treated
+// as a body longer than declared.
+func RequestFromReaderWithOptions(r io.Reader, opts Options) (*Request, error) {
+	reader := NewReader(r, opts)
+	request, err := reader.ReadRequest()
+	if err != nil {
+		return nil, err
+	}
+	if request.bodyLength > 0 && len(reader.Buffered()) > 0 {
+		return nil, fmt.Errorf("error: body longer that Content-Length")
+	}
+	return request, nil
+}
+
+// Reader reads consecutive requests from a connection. Bytes read past the
+// end of one request are kept for the next one.
+type Reader struct {
+	r           io.Reader
+	options     Options
+	buff        []byte
+	readToIndex int
+}
+
+func NewReader(r io.Reader, opts Options) *Reader {
+	return &Reader{
+		r:       r,
+		options: opts,
+		buff:    make([]byte, bufferSize),
+	}
+}
+
+// Buffered returns the bytes already read from the connection that do not
+// belong to any request returned so far.
+func (rr *Reader) Buffered() []byte {
+	return rr.buff[:rr.readToIndex]
+}
+
+// ReadRequest reads the next request. It returns io.EOF when the connection
+// is closed before another request starts.
+func (rr *Reader) ReadRequest() (*Request, error) {
 	request := &Request{
-		state:   requestStateInitialized,
-		Headers: headers.NewHeaders(),
+		state:    requestStateInitialized,
+		options:  rr.options,
+		Headers:  headers.NewHeaders(),
+		Trailers: headers.NewHeaders(),
 	}
-	for request.state != requestStateDone {
-		if readToIndex >= len(buff) {
-			tmp := make([]byte, len(buff)*2)
-			copy(tmp, buff)
-			buff = tmp
+	for {
+		// a pipelined request may already be complete in the buffer
+		bytesParsed, err := request.parse(rr.buff[:rr.readToIndex])
+		if err != nil {
+			return nil, err
+		}
+		copy(rr.buff, rr.buff[bytesParsed:rr.readToIndex])
+		rr.readToIndex -= bytesParsed
+		if request.state == requestStateDone {
+			return request, nil
 		}
 
-		bytesRead, err := r.Read(buff[readToIndex:])
-		if err != nil {
+		if rr.readToIndex >= len(rr.buff) {
+			tmp := make([]byte, len(rr.buff)*2)
+			copy(tmp, rr.buff)
+			rr.buff = tmp
+		}
+
+		bytesRead, err := rr.r.Read(rr.buff[rr.readToIndex:])
+		rr.readToIndex += bytesRead
+		if err != nil && bytesRead == 0 {
 			if errors.Is(err, io.EOF) {
-				if request.state != requestStateDone {
-					return nil, fmt.Errorf("incomplete request, in state: %d, read n bytes on EOF: %d", request.state, bytesRead)
+				if request.state == requestStateInitialized && rr.readToIndex == 0 {
+					return nil, io.EOF
 				}
-				break
+				return nil, fmt.Errorf("incomplete request, in state: %d, read n bytes on EOF: %d", request.state, bytesRead)
 			}
 			return nil, err
 		}
-		readToIndex += bytesRead
-
-		bytesParsed, err := request.parse(buff[:readToIndex])
-		if err != nil {
-			return nil, err
-		}
-
-		copy(buff, buff[bytesParsed:])
-		readToIndex -= bytesParsed
 	}
-
-	return request, nil
 }
 
 func (r *Request) parse(data []byte) (int, error) {
@@ -104,32 +167,66 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 		r.state = requestStateParsingHeaders
 		return n, nil
 	case requestStateParsingHeaders:
-		n, done, err := r.Headers.Parse(data)
+		n, done, err := r.Headers.ParseWithOptions(data, r.options.Headers)
 		if err != nil {
 			return 0, err
 		}
 		if done {
-			r.state = requestStateParsingBody
+			if err := r.parseHost(); err != nil {
+				return 0, err
+			}
+			if err := r.prepareBody(); err != nil {
+				return 0, err
+			}
 		}
 		return n, nil
 	case requestStateParsingBody:
-		bodyLenStr := r.Headers.Get("Content-Length")
-		if bodyLenStr == "" {
+		n := min(len(data), r.bodyLength-len(r.Body))
+		r.Body = append(r.Body, data[:n]...)
+		if len(r.Body) == r.bodyLength {
 			r.state = requestStateDone
+		}
+		return n, nil
+	case requestStateParsingChunkSize:
+		idx := bytes.Index(data, []byte(crlf))
+		if idx == -1 {
 			return 0, nil
 		}
-		bodyLength, err := strconv.Atoi(bodyLenStr)
+		size, err := parseChunkSize(string(data[:idx]))
 		if err != nil {
-			return 0, fmt.Errorf("error: parsing Content-Length: %v", err)
+			return 0, err
+		}
+		if size == 0 {
+			r.state = requestStateParsingTrailers
+		} else {
+			r.chunkRemaining = size
+			r.state = requestStateParsingChunkData
+		}
+		return idx + len(crlf), nil
+	case requestStateParsingChunkData:
+		if r.chunkRemaining > 0 {
+			n := min(len(data), r.chunkRemaining)
+			r.Body = append(r.Body, data[:n]...)
+			r.chunkRemaining -= n
+			return n, nil
+		}
+		if len(data) < len(crlf) {
+			return 0, nil
 		}
-		r.Body = append(r.Body, data...)
-		if len(r.Body) > bodyLength {
-			return 0, fmt.Errorf("error: body longer that Content-Length")
+		if !bytes.HasPrefix(data, []byte(crlf)) {
+			return 0, fmt.Errorf("error: missing CRLF after chunk data")
 		}
-		if len(r.Body) == bodyLength {
+		r.state = requestStateParsingChunkSize
+		return len(crlf), nil
+	case requestStateParsingTrailers:
+		n, done, err := r.Trailers.ParseWithOptions(data, r.options.Headers)
+		if err != nil {
+			return 0, err
+		}
+		if done {
 			r.state = requestStateDone
 		}
-		return len(data), nil
+		return n, nil
 	case requestStateDone:
 		return 0, fmt.Errorf("error: trying to read data in a done state")
 	default:
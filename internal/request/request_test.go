@@ -1,9 +1,16 @@
 package request
 
 import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
 	"io"
+	"strconv"
+	"strings"
 	"testing"
 
+	"github.com/xixotron/httpfromtcp/internal/headers"
+
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -132,16 +139,14 @@ func TestParseRequestHeaders(t *testing.T) {
 	assert.Equal(t, "curl/7.81.0", r.Headers["user-agent"])
 	assert.Equal(t, "*/*", r.Headers["accept"])
 
-	// Test: Empty Headers
+	// Test: Empty Headers, HTTP/1.1 requires Host
 	reader = &chunkReader{
 		data:            "GET / HTTP/1.1\r\n\r\n",
 		numBytesPerRead: 1,
 	}
 	r, err = RequestFromReader(reader)
-	require.NoError(t, err)
-	require.NotNil(t, r)
-	require.NotNil(t, r.Headers)
-	assert.Equal(t, 0, len(r.Headers))
+	require.ErrorIs(t, err, ErrMissingHost)
+	require.Nil(t, r)
 
 	// Test: Malformed Header
 	reader = &chunkReader{
@@ -153,7 +158,7 @@ func TestParseRequestHeaders(t *testing.T) {
 
 	// Test: Duplicate Headers
 	reader = &chunkReader{
-		data:            "GET / HTTP/1.1\r\nUser-Agent: curl/7.81.0\r\nAccept: text/xml\r\nAccept: text/json\r\n\r\n",
+		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: text/xml\r\nAccept: text/json\r\n\r\n",
 		numBytesPerRead: 16,
 	}
 	r, err = RequestFromReader(reader)
@@ -164,7 +169,7 @@ func TestParseRequestHeaders(t *testing.T) {
 
 	// Test: Case Insensitive Headers
 	reader = &chunkReader{
-		data:            "GET / HTTP/1.1\r\nUser-Agent: curl/7.81.0\r\nACCEPT: text/xml\r\naccept: text/json\r\n\r\n",
+		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nACCEPT: text/xml\r\naccept: text/json\r\n\r\n",
 		numBytesPerRead: 16,
 	}
 	r, err = RequestFromReader(reader)
@@ -173,6 +178,27 @@ func TestParseRequestHeaders(t *testing.T) {
 	assert.Equal(t, "curl/7.81.0", r.Headers["user-agent"])
 	assert.Equal(t, "text/xml,text/json", r.Headers["accept"])
 
+	// Test: Obs-fold rejected by default
+	reader = &chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nX-Long: first\r\n second\r\n\r\n",
+		numBytesPerRead: 4,
+	}
+	r, err = RequestFromReader(reader)
+	require.Error(t, err)
+
+	// Test: Obs-fold replaced when configured
+	reader = &chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nX-Long: first\r\n second\r\n\r\n",
+		numBytesPerRead: 4,
+	}
+	r, err = RequestFromReaderWithOptions(reader, Options{
+		Headers: headers.ParseOptions{ObsFold: headers.ObsFoldReplace},
+	})
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "first second", r.Headers["x-long"])
+	assert.Equal(t, "localhost:42069", r.Headers["host"])
+
 	// Test: Missing end of Headers
 	reader = &chunkReader{
 		data:            "GET / HTTP/1.1\r\nUser-Agent: curl/7.81.0",
@@ -258,3 +284,352 @@ func TestParseRequestBody(t *testing.T) {
 	require.NotNil(t, r)
 	assert.Len(t, r.Body, 0)
 }
+
+func TestParseRequestFraming(t *testing.T) {
+	// Test: Chunked body
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"6\r\nhello \r\n" +
+			"7;ext=1\r\nworld!\n\r\n" +
+			"0\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "hello world!\n", string(r.Body))
+
+	// Test: Chunked body with trailers
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"A\r\n0123456789\r\n" +
+			"0\r\n" +
+			"X-Checksum: abc\r\n" +
+			"\r\n",
+		numBytesPerRead: 1,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "0123456789", string(r.Body))
+	assert.Equal(t, "abc", r.Trailers["x-checksum"])
+
+	// Test: Invalid chunk size
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"zz\r\nhello\r\n0\r\n\r\n",
+		numBytesPerRead: 8,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+
+	// Test: Both Transfer-Encoding and Content-Length
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 5\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"0\r\n\r\n",
+		numBytesPerRead: 8,
+	}
+	_, err = RequestFromReader(reader)
+	require.ErrorIs(t, err, ErrAmbiguousFraming)
+
+	// Test: Conflicting duplicate Content-Length
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 5\r\n" +
+			"Content-Length: 6\r\n" +
+			"\r\n" +
+			"hello!",
+		numBytesPerRead: 8,
+	}
+	_, err = RequestFromReader(reader)
+	require.ErrorIs(t, err, ErrInvalidContentLength)
+
+	// Test: Identical duplicate Content-Length
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 5\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"hello",
+		numBytesPerRead: 8,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(r.Body))
+
+	// Test: Signed and negative Content-Length
+	for _, length := range []string{"+5", "-5", "0x5", "5 5", ""} {
+		reader = &chunkReader{
+			data: "POST /submit HTTP/1.1\r\n" +
+				"Host: localhost:42069\r\n" +
+				"Content-Length: " + length + "\r\n" +
+				"\r\n" +
+				"hello",
+			numBytesPerRead: 8,
+		}
+		_, err = RequestFromReader(reader)
+		require.ErrorIs(t, err, ErrInvalidContentLength, length)
+	}
+
+	// Test: Unknown transfer coding
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: gzip, chunked\r\n" +
+			"\r\n" +
+			"0\r\n\r\n",
+		numBytesPerRead: 8,
+	}
+	_, err = RequestFromReader(reader)
+	require.ErrorIs(t, err, ErrUnsupportedTransferCoding)
+
+	// Test: Chunked applied twice
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"0\r\n\r\n",
+		numBytesPerRead: 8,
+	}
+	_, err = RequestFromReader(reader)
+	require.ErrorIs(t, err, ErrInvalidTransferEncoding)
+}
+
+func TestParseRequestHost(t *testing.T) {
+	// Test: Host with port is normalized
+	reader := &chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: WWW.Example.COM.:8080\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "www.example.com", r.Host)
+	assert.Equal(t, "8080", r.Port)
+
+	// Test: Host without port
+	reader = &chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "localhost", r.Host)
+	assert.Equal(t, "", r.Port)
+
+	// Test: IPv6 literal Host
+	reader = &chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: [0:0::1]:42069\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "[::1]", r.Host)
+	assert.Equal(t, "42069", r.Port)
+
+	// Test: Duplicate Host
+	reader = &chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: a.example\r\nHost: b.example\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.ErrorIs(t, err, ErrInvalidHost)
+
+	// Test: Invalid Host values
+	for _, host := range []string{"exa mple.com", "example.com:http", "[::1", "[127.0.0.1]", "a/b", "example.com:123456"} {
+		reader = &chunkReader{
+			data:            "GET / HTTP/1.1\r\nHost: " + host + "\r\n\r\n",
+			numBytesPerRead: 8,
+		}
+		r, err = RequestFromReader(reader)
+		require.ErrorIs(t, err, ErrInvalidHost, host)
+	}
+}
+
+func TestDecodeBody(t *testing.T) {
+	payload := bytes.Repeat([]byte("telemetry "), 100)
+	gzipped := &bytes.Buffer{}
+	gz := gzip.NewWriter(gzipped)
+	gz.Write(payload)
+	gz.Close()
+
+	newRequest := func(contentEncoding string, body []byte) *Request {
+		reader := &chunkReader{
+			data: "POST /ingest HTTP/1.1\r\n" +
+				"Host: localhost:42069\r\n" +
+				"Content-Encoding: " + contentEncoding + "\r\n" +
+				"Content-Length: " + strconv.Itoa(len(body)) + "\r\n" +
+				"\r\n" +
+				string(body),
+			numBytesPerRead: 64,
+		}
+		r, err := RequestFromReader(reader)
+		require.NoError(t, err)
+		return r
+	}
+
+	// Test: Gzip body
+	r := newRequest("gzip", gzipped.Bytes())
+	require.NoError(t, r.DecodeBody(len(payload)))
+	assert.Equal(t, payload, r.Body)
+	assert.Equal(t, "", r.Headers.Get("Content-Encoding"))
+	assert.Equal(t, strconv.Itoa(len(payload)), r.Headers.Get("Content-Length"))
+
+	// Test: Deflate applied after gzip
+	deflated := &bytes.Buffer{}
+	zw := zlib.NewWriter(deflated)
+	zw.Write(gzipped.Bytes())
+	zw.Close()
+	r = newRequest("gzip, deflate", deflated.Bytes())
+	require.NoError(t, r.DecodeBody(len(payload)))
+	assert.Equal(t, payload, r.Body)
+
+	// Test: Decoded body over the limit
+	r = newRequest("gzip", gzipped.Bytes())
+	require.ErrorIs(t, r.DecodeBody(len(payload)-1), ErrBodyTooLarge)
+
+	// Test: Unsupported coding
+	r = newRequest("br", []byte("whatever"))
+	require.ErrorIs(t, r.DecodeBody(1024), ErrUnsupportedContentEncoding)
+
+	// Test: Corrupt gzip data
+	r = newRequest("gzip", []byte("not gzip at all"))
+	err := r.DecodeBody(1024)
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrBodyTooLarge)
+
+	// Test: No Content-Encoding leaves the body untouched
+	reader := &chunkReader{
+		data:            "POST /ingest HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 5\r\n\r\nhello",
+		numBytesPerRead: 64,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NoError(t, r.DecodeBody(1))
+	assert.Equal(t, "hello", string(r.Body))
+}
+
+func TestReader(t *testing.T) {
+	// Test: Pipelined requests are read one after another
+	reader := NewReader(&chunkReader{
+		data: "POST /one HTTP/1.1\r\nHost: localhost\r\nContent-Length: 3\r\n\r\nabc" +
+			"POST /two HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nhi\r\n0\r\n\r\n" +
+			"GET /three HTTP/1.1\r\nHost: localhost\r\n\r\n",
+		numBytesPerRead: 64,
+	}, Options{})
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/one", r.RequestLine.RequestTarget)
+	assert.Equal(t, "abc", string(r.Body))
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/two", r.RequestLine.RequestTarget)
+	assert.Equal(t, "hi", string(r.Body))
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/three", r.RequestLine.RequestTarget)
+
+	// Test: A clean end of input between requests is io.EOF
+	_, err = reader.ReadRequest()
+	require.ErrorIs(t, err, io.EOF)
+
+	// Test: Bytes following a request stay buffered
+	reader = NewReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\n\r\nnot http"), Options{})
+	_, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "not http", string(reader.Buffered()))
+
+	// Test: Input ending inside a request is not io.EOF
+	reader = NewReader(strings.NewReader("GET / HTTP/1.1\r\nHost: loc"), Options{})
+	_, err = reader.ReadRequest()
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, io.EOF)
+}
+
+func TestRequestWrite(t *testing.T) {
+	// Test: Content-Length body round trips
+	r, err := RequestFromReader(strings.NewReader("POST /up HTTP/1.1\r\nHost: x\r\nContent-Length: 5\r\n\r\nhello"))
+	require.NoError(t, err)
+	var buff bytes.Buffer
+	require.NoError(t, r.Write(&buff))
+	again, err := RequestFromReader(&buff)
+	require.NoError(t, err)
+	assert.Equal(t, r.RequestLine, again.RequestLine)
+	assert.Equal(t, r.Headers, again.Headers)
+	assert.Equal(t, "hello", string(again.Body))
+
+	// Test: Chunked body keeps its framing and trailers
+	r, err = RequestFromReader(strings.NewReader("POST /up HTTP/1.1\r\nHost: x\r\n" +
+		"Transfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n2\r\nde\r\n0\r\nX-Sum: 5\r\n\r\n"))
+	require.NoError(t, err)
+	buff.Reset()
+	require.NoError(t, r.Write(&buff))
+	again, err = RequestFromReader(&buff)
+	require.NoError(t, err)
+	assert.Equal(t, "abcde", string(again.Body))
+	assert.Equal(t, "5", again.Trailers.Get("X-Sum"))
+
+	// Test: Missing Content-Length is added for a body
+	r = &Request{
+		RequestLine: RequestLine{Method: "PUT", RequestTarget: "/x", HttpVersion: "1.1"},
+		Headers:     headers.NewHeaders(),
+		Body:        []byte("data"),
+	}
+	r.Headers.Set("Host", "x")
+	buff.Reset()
+	require.NoError(t, r.Write(&buff))
+	assert.Equal(t, "PUT /x HTTP/1.1\r\nhost: x\r\ncontent-length: 4\r\n\r\ndata", buff.String())
+}
+
+func TestCookies(t *testing.T) {
+	cookieRequest := func(value string) *Request {
+		r := &Request{Headers: headers.NewHeaders()}
+		r.Headers.Set("Cookie", value)
+		return r
+	}
+
+	// Test: Pairs in order
+	r := cookieRequest(`session=abc123; theme="dark"; empty=`)
+	assert.Equal(t, []Cookie{{"session", "abc123"}, {"theme", "dark"}, {"empty", ""}}, r.Cookies())
+	value, ok := r.Cookie("theme")
+	assert.True(t, ok)
+	assert.Equal(t, "dark", value)
+	_, ok = r.Cookie("Theme")
+	assert.False(t, ok)
+
+	// Test: Malformed pairs are skipped
+	r = cookieRequest(`a=1;;  noequals ; bad name=2; b=has space; c="unterminated; d=4 ;`)
+	assert.Equal(t, []Cookie{{"a", "1"}, {"d", "4"}}, r.Cookies())
+
+	// Test: Commas do not separate pairs, a value holding one is dropped whole
+	r = cookieRequest(`a=x,y; b=2`)
+	assert.Equal(t, []Cookie{{"b", "2"}}, r.Cookies())
+
+	// Test: Repeated Cookie headers
+	r = cookieRequest("a=1")
+	r.Headers.Set("Cookie", "b=2")
+	assert.Equal(t, "a=1; b=2", r.Headers.Get("Cookie"))
+	assert.Equal(t, []Cookie{{"a", "1"}, {"b", "2"}}, r.Cookies())
+
+	// Test: No Cookie header
+	r = &Request{Headers: headers.NewHeaders()}
+	assert.Empty(t, r.Cookies())
+}
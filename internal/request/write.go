@@ -0,0 +1,44 @@
+package request
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Write sends the request to w in wire format. The body is framed the way the
+// headers say: a chunked request is sent as a single chunk followed by its
+// trailers, anything else with a Content-Length, added if missing.
+func (r *Request) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%s %s HTTP/%s\r\n", r.RequestLine.Method, r.RequestLine.RequestTarget, r.RequestLine.HttpVersion)
+	for key, value := range r.Headers {
+		fmt.Fprintf(bw, "%s: %s\r\n", key, value)
+	}
+
+	if r.chunked() {
+		bw.WriteString("\r\n")
+		if len(r.Body) > 0 {
+			fmt.Fprintf(bw, "%x\r\n%s\r\n", len(r.Body), r.Body)
+		}
+		bw.WriteString("0\r\n")
+		for key, value := range r.Trailers {
+			fmt.Fprintf(bw, "%s: %s\r\n", key, value)
+		}
+		bw.WriteString("\r\n")
+		return bw.Flush()
+	}
+
+	if _, ok := r.Headers["content-length"]; !ok && len(r.Body) > 0 {
+		fmt.Fprintf(bw, "content-length: %s\r\n", strconv.Itoa(len(r.Body)))
+	}
+	bw.WriteString("\r\n")
+	bw.Write(r.Body)
+	return bw.Flush()
+}
+
+func (r *Request) chunked() bool {
+	te, ok := r.Headers["transfer-encoding"]
+	return ok && validateTransferEncoding(te) == nil
+}
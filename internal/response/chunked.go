@@ -0,0 +1,176 @@
+package response
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xixotron/httpfromtcp/internal/headers"
+)
+
+// DefaultChunkSize is the chunk size ChunkedWriter uses when given none.
+const DefaultChunkSize = 16 * 1024
+
+// ChunkExtension is a name=value pair sent after the size of a chunk (RFC
+// 9112 section 7.1.1). An empty Value sends the name alone.
+type ChunkExtension struct {
+	Name  string
+	Value string
+}
+
+// formatExtensions renders ext as it follows a chunk size, quoting values
+// that are not tokens.
+func formatExtensions(ext []ChunkExtension) (string, error) {
+	var b strings.Builder
+	for _, e := range ext {
+		if !headers.IsToken(e.Name) {
+			return "", fmt.Errorf("invalid chunk extension name %q", e.Name)
+		}
+		b.WriteString(";" + e.Name)
+		if e.Value == "" {
+			continue
+		}
+		if headers.IsToken(e.Value) {
+			b.WriteString("=" + e.Value)
+			continue
+		}
+		quoted, err := quoteString(e.Value)
+		if err != nil {
+			return "", fmt.Errorf("invalid chunk extension value for %s: %w", e.Name, err)
+		}
+		b.WriteString("=" + quoted)
+	}
+	return b.String(), nil
+}
+
+// quoteString returns s as a quoted-string, escaping quotes and backslashes.
+func quoteString(s string) (string, error) {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '\t' || (c >= 0x20 && c != 0x7f):
+			b.WriteByte(c)
+		default:
+			return "", fmt.Errorf("control character %q", c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String(), nil
+}
+
+// ChunkedWriter is an io.Writer for a chunked body that decides the framing
+// itself: small writes are gathered until a whole chunk of the configured
+// size is ready, large ones are split into chunks of that size, and empty
+// writes send nothing, so only Close ends the body.
+//
+// When the response is compressed, the data goes through the compressor,
+// which makes its own chunks, and extensions are not sent.
+type ChunkedWriter struct {
+	w         *Writer
+	size      int
+	buf       []byte
+	extension string
+}
+
+// ChunkedWriter returns a ChunkedWriter sending chunks of size bytes, or of
+// DefaultChunkSize when size is not positive. The headers must have set
+// Transfer-Encoding: chunked.
+func (w *Writer) ChunkedWriter(size int) (*ChunkedWriter, error) {
+	if err := w.checkChunked("chunked writer"); err != nil {
+		return nil, err
+	}
+	if size <= 0 {
+		size = DefaultChunkSize
+	}
+	return &ChunkedWriter{w: w, size: size}, nil
+}
+
+// SetExtensions attaches ext to every chunk sent from now on, replacing the
+// extensions set before. Data already written but not yet sent gets them
+// too.
+func (cw *ChunkedWriter) SetExtensions(ext ...ChunkExtension) error {
+	extension, err := formatExtensions(ext)
+	if err != nil {
+		return err
+	}
+	cw.extension = extension
+	return nil
+}
+
+func (cw *ChunkedWriter) Write(p []byte) (int, error) {
+	if err := cw.w.checkChunked("write chunk"); err != nil {
+		return 0, err
+	}
+	if cw.w.encoder != nil {
+		return cw.w.encoder.Write(p)
+	}
+
+	written := 0
+	// a write filling the pending chunk completes it, whole chunks are then
+	// sent straight from p
+	if len(cw.buf) > 0 && len(cw.buf)+len(p) >= cw.size {
+		pending := len(cw.buf)
+		n := cw.size - pending
+		cw.buf = append(cw.buf, p[:n]...)
+		if err := cw.send(cw.buf); err != nil {
+			// none of p counts as written, so none of it may stay behind
+			// to be sent again when the caller retries
+			cw.buf = cw.buf[:pending]
+			return 0, err
+		}
+		cw.buf = cw.buf[:0]
+		p = p[n:]
+		written += n
+	}
+	for len(p) >= cw.size {
+		if err := cw.send(p[:cw.size]); err != nil {
+			return written, err
+		}
+		p = p[cw.size:]
+		written += cw.size
+	}
+	cw.buf = append(cw.buf, p...)
+	return written + len(p), nil
+}
+
+// Flush sends the data gathered so far as a chunk, even if it is short of
+// the chunk size, and flushes the Writer.
+func (cw *ChunkedWriter) Flush() error {
+	if err := cw.sendPending(); err != nil {
+		return err
+	}
+	return cw.w.Flush()
+}
+
+// Close sends the data gathered so far and ends the body, with the trailers
+// set on the Writer.
+func (cw *ChunkedWriter) Close() error {
+	if err := cw.sendPending(); err != nil {
+		return err
+	}
+	_, err := cw.w.WriteChunkedBodyDone()
+	return err
+}
+
+func (cw *ChunkedWriter) sendPending() error {
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	if err := cw.w.checkChunked("write chunk"); err != nil {
+		return err
+	}
+	if err := cw.send(cw.buf); err != nil {
+		return err
+	}
+	cw.buf = cw.buf[:0]
+	return nil
+}
+
+func (cw *ChunkedWriter) send(p []byte) error {
+	_, err := cw.w.writeChunkExt(p, cw.extension)
+	return err
+}
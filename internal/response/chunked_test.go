@@ -0,0 +1,127 @@
+package response
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xixotron/httpfromtcp/internal/headers"
+)
+
+func chunkedWriter(t *testing.T, buf *bytes.Buffer, size int) (*Writer, *ChunkedWriter) {
+	t.Helper()
+	w := NewWriter(buf)
+	h := GetDefaultHeaders(0)
+	h.Remove("Content-Length")
+	h.Set("Transfer-Encoding", "chunked")
+	h.Set("Trailer", "X-Sum")
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(h))
+	require.NoError(t, w.Flush())
+	buf.Reset()
+	cw, err := w.ChunkedWriter(size)
+	require.NoError(t, err)
+	return w, cw
+}
+
+func TestChunkedWriter(t *testing.T) {
+	// Test: Small writes are gathered into chunks of the configured size
+	var buf bytes.Buffer
+	w, cw := chunkedWriter(t, &buf, 4)
+	for _, s := range []string{"a", "bc", "", "d", "ef"} {
+		_, err := cw.Write([]byte(s))
+		require.NoError(t, err)
+	}
+	require.NoError(t, cw.Flush())
+	assert.Equal(t, "4\r\nabcd\r\n2\r\nef\r\n", buf.String())
+
+	// Test: Large writes are split, the rest waits for more
+	buf.Reset()
+	n, err := cw.Write([]byte("0123456789"))
+	require.NoError(t, err)
+	assert.Equal(t, 10, n)
+	require.NoError(t, w.Flush())
+	assert.Equal(t, "4\r\n0123\r\n4\r\n4567\r\n", buf.String())
+
+	// Test: Close sends the rest and the trailers
+	buf.Reset()
+	require.NoError(t, w.SetTrailer("X-Sum", "1"))
+	require.NoError(t, cw.Close())
+	assert.Equal(t, "2\r\n89\r\n0\r\nx-sum: 1\r\n\r\n", buf.String())
+	assert.Equal(t, StateDone, w.State())
+	_, err = cw.Write([]byte("late"))
+	require.ErrorIs(t, err, ErrBodyAfterDone)
+
+	// Test: Empty writes never end the body
+	buf.Reset()
+	w, cw = chunkedWriter(t, &buf, 0)
+	_, err = cw.Write(nil)
+	require.NoError(t, err)
+	require.NoError(t, cw.Flush())
+	_, err = w.WriteChunkedBody(nil)
+	require.NoError(t, err)
+	require.NoError(t, w.Flush())
+	assert.Equal(t, "", buf.String())
+	require.NoError(t, cw.Close())
+	assert.Equal(t, "0\r\n\r\n", buf.String())
+
+	// Test: Extensions are attached to the chunks
+	buf.Reset()
+	_, cw = chunkedWriter(t, &buf, 3)
+	require.NoError(t, cw.SetExtensions(ChunkExtension{Name: "seq", Value: "1"}, ChunkExtension{Name: "note", Value: `say "hi"`}, ChunkExtension{Name: "last"}))
+	_, err = cw.Write([]byte("abc"))
+	require.NoError(t, err)
+	require.NoError(t, cw.SetExtensions())
+	require.NoError(t, cw.SetExtensions(ChunkExtension{Name: "seq", Value: "2"}))
+	_, err = cw.Write([]byte("d"))
+	require.NoError(t, err)
+	require.NoError(t, cw.Close())
+	assert.Equal(t, "3;seq=1;note=\"say \\\"hi\\\"\";last\r\nabc\r\n1;seq=2\r\nd\r\n0\r\n\r\n", buf.String())
+
+	// Test: Invalid extensions
+	require.Error(t, cw.SetExtensions(ChunkExtension{Name: "bad name"}))
+	require.Error(t, cw.SetExtensions(ChunkExtension{Name: "x", Value: "line\r\nbreak"}))
+
+	// Test: Only chunked responses get a ChunkedWriter
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(0)))
+	_, err = w.ChunkedWriter(0)
+	require.ErrorIs(t, err, ErrChunkedNotEnabled)
+}
+
+// breakingWriter accepts writes until broken is set, and fails them after.
+type breakingWriter struct {
+	broken bool
+}
+
+func (bw *breakingWriter) Write(p []byte) (int, error) {
+	if bw.broken {
+		return 0, errors.New("connection reset")
+	}
+	return len(p), nil
+}
+
+func TestChunkedWriterError(t *testing.T) {
+	conn := &breakingWriter{}
+	w := NewWriterSize(conn, 16)
+	h := headers.NewHeaders()
+	h.Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(h))
+	cw, err := w.ChunkedWriter(32)
+	require.NoError(t, err)
+	_, err = cw.Write([]byte("0123"))
+	require.NoError(t, err)
+	require.NoError(t, w.Flush())
+	conn.broken = true
+
+	// Test: A failed write leaves nothing of p behind to be sent twice
+	n, err := cw.Write(bytes.Repeat([]byte("x"), 40))
+	require.Error(t, err)
+	assert.Zero(t, n)
+	assert.Equal(t, "0123", string(cw.buf))
+}
@@ -0,0 +1,193 @@
+package response
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/xixotron/httpfromtcp/internal/headers"
+)
+
+// minCompressSize is the smallest declared Content-Length worth compressing,
+// below it the gzip framing overhead outweighs the savings.
+const minCompressSize = 512
+
+// supportedEncodings lists the content codings the Writer can apply, in order
+// of preference when the client rates them equally.
+var supportedEncodings = []string{"gzip", "deflate"}
+
+// NegotiateEncoding picks the best content coding out of supported for the
+// given Accept-Encoding value, honouring q-values and the "*" wildcard. It
+// returns "" when the body should be sent without a content coding.
+func NegotiateEncoding(acceptEncoding string, supported ...string) string {
+	acceptEncoding = strings.TrimSpace(acceptEncoding)
+	if acceptEncoding == "" {
+		return ""
+	}
+
+	qualities := map[string]float64{}
+	for part := range strings.SplitSeq(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+		qualities[coding] = parseQuality(params)
+	}
+
+	best, bestQuality := "", 0.0
+	for _, coding := range supported {
+		q, ok := qualities[coding]
+		if !ok {
+			q, ok = qualities["*"]
+		}
+		if ok && q > bestQuality {
+			best, bestQuality = coding, q
+		}
+	}
+	return best
+}
+
+// parseQuality reads the q parameter out of the parameters following a list
+// element, defaulting to 1 when it is absent and to 0 when it is malformed.
+func parseQuality(params string) float64 {
+	for param := range strings.SplitSeq(params, ";") {
+		name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		if strings.ToLower(strings.TrimSpace(name)) != "q" {
+			continue
+		}
+		q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || q < 0 || q > 1 {
+			return 0
+		}
+		return q
+	}
+	return 1
+}
+
+// compressible reports whether a body of the given Content-Type benefits from
+// compression. Media that is already compressed, such as video, images other
+// than SVG and archives, is left alone.
+func compressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+
+	if strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "+json") ||
+		strings.HasSuffix(mediaType, "+xml") {
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/javascript", "application/xml",
+		"application/wasm", "image/svg+xml":
+		return true
+	}
+	return false
+}
+
+// EnableCompression lets the Writer compress the body when the client's
+// Accept-Encoding and the response's Content-Type allow it. Ranges and
+// responses that announce trailers are always sent as written. It must be
+// called before WriteHeaders.
+func (w *Writer) EnableCompression(acceptEncoding string) {
+	w.acceptEncoding = acceptEncoding
+	w.compression = true
+}
+
+// prepareCompression decides, from the headers about to be written, whether
+// the body gets compressed. When it does, it returns a copy of h with the
+// framing headers adjusted and installs the body encoder.
+func (w *Writer) prepareCompression(h headers.Headers) (headers.Headers, error) {
+	if !w.compression || !bodyAllowed(w.statusCode) {
+		return h, nil
+	}
+	if h.Get("Content-Encoding") != "" || !compressible(h.Get("Content-Type")) {
+		return h, nil
+	}
+	// Content-Range counts bytes of the uncompressed representation
+	if w.statusCode == StatusPartialContent || h.Get("Content-Range") != "" {
+		return h, nil
+	}
+	// trailers may carry a digest of the body as written, such as the
+	// reverse proxy's X-Content-SHA256, which compression would break
+	if h.Get("Trailer") != "" {
+		return h, nil
+	}
+
+	out := headers.NewHeaders()
+	for key, value := range h {
+		out[key] = value
+	}
+	if !out.HasToken("Vary", "Accept-Encoding") {
+		out.Set("Vary", "Accept-Encoding")
+	}
+
+	if lenStr := h.Get("Content-Length"); lenStr != "" {
+		if length, err := strconv.Atoi(lenStr); err == nil && length < minCompressSize {
+			return out, nil
+		}
+	}
+
+	encoding := NegotiateEncoding(w.acceptEncoding, supportedEncodings...)
+	if encoding == "" {
+		return out, nil
+	}
+
+	// the compressed length is not known up front, so the body is always
+	// streamed in chunks
+	out.Remove("Content-Length")
+	out.Override("Transfer-Encoding", "chunked")
+	out.Override("Content-Encoding", encoding)
+	// the encoded bytes differ from the original, so a strong validator of
+	// the original no longer holds for them
+	if etag := out.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		out.Override("ETag", "W/"+etag)
+	}
+
+	chunks := &chunkWriter{w: w}
+	switch encoding {
+	case "gzip":
+		w.encoder = gzip.NewWriter(chunks)
+	case "deflate":
+		w.encoder = zlib.NewWriter(chunks)
+	default:
+		return nil, fmt.Errorf("unsupported content coding %s", encoding)
+	}
+	return out, nil
+}
+
+// closeEncoder flushes whatever the encoder still buffers as final chunks.
+func (w *Writer) closeEncoder() error {
+	if w.encoder == nil {
+		return nil
+	}
+	err := w.encoder.Close()
+	w.encoder = nil
+	return err
+}
+
+// bodyAllowed reports whether a response with the given status may carry a
+// body at all (RFC 9110 section 6.4.1).
+func bodyAllowed(statusCode StatusCode) bool {
+	return statusCode >= 200 && statusCode != 204 && statusCode != 304
+}
+
+// chunkWriter frames everything written to it as chunks of the response body.
+type chunkWriter struct {
+	w *Writer
+}
+
+func (cw *chunkWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if _, err := cw.w.writeChunk(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+var _ io.Writer = (*chunkWriter)(nil)
@@ -0,0 +1,203 @@
+package response
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xixotron/httpfromtcp/internal/headers"
+	"github.com/xixotron/httpfromtcp/internal/request"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	// Test: Empty header means identity
+	assert.Equal(t, "", NegotiateEncoding("", "gzip", "deflate"))
+
+	// Test: Single supported coding
+	assert.Equal(t, "gzip", NegotiateEncoding("gzip", "gzip", "deflate"))
+
+	// Test: Server preference on ties
+	assert.Equal(t, "gzip", NegotiateEncoding("deflate, gzip", "gzip", "deflate"))
+
+	// Test: Highest q-value wins
+	assert.Equal(t, "deflate", NegotiateEncoding("gzip;q=0.5, deflate;q=0.8", "gzip", "deflate"))
+
+	// Test: q=0 disables a coding
+	assert.Equal(t, "deflate", NegotiateEncoding("gzip;q=0, deflate", "gzip", "deflate"))
+
+	// Test: Wildcard
+	assert.Equal(t, "gzip", NegotiateEncoding("br, *;q=0.1", "gzip", "deflate"))
+	assert.Equal(t, "deflate", NegotiateEncoding("gzip;q=0, *", "gzip", "deflate"))
+	assert.Equal(t, "", NegotiateEncoding("*;q=0", "gzip", "deflate"))
+
+	// Test: Unsupported only
+	assert.Equal(t, "", NegotiateEncoding("br, zstd", "gzip", "deflate"))
+
+	// Test: Case and whitespace insensitive
+	assert.Equal(t, "gzip", NegotiateEncoding("  GZIP ; Q=1 ", "gzip", "deflate"))
+}
+
+// readResponse parses the status line and headers of a raw response and
+// returns the headers and the de-chunked body.
+func readResponse(t *testing.T, raw []byte) (headers.Headers, []byte) {
+	t.Helper()
+	reader := bufio.NewReader(bytes.NewReader(raw))
+	_, err := reader.ReadString('\n')
+	require.NoError(t, err)
+
+	h := headers.NewHeaders()
+	rest, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	consumed := 0
+	for {
+		n, done, err := h.Parse(rest[consumed:])
+		require.NoError(t, err)
+		consumed += n
+		if done {
+			break
+		}
+		require.NotZero(t, n)
+	}
+	body := rest[consumed:]
+	if h.Get("Transfer-Encoding") != "chunked" {
+		return h, body
+	}
+
+	// reuse the request parser to decode the chunked body
+	req, err := request.RequestFromReader(strings.NewReader(
+		"POST / HTTP/1.1\r\nHost: test\r\nTransfer-Encoding: chunked\r\n\r\n" + string(body),
+	))
+	require.NoError(t, err)
+	return h, req.Body
+}
+
+func TestWriterCompression(t *testing.T) {
+	page := []byte(strings.Repeat("<p>Your request was an absolute banger.</p>\n", 50))
+
+	// Test: Gzip fixed length body
+	buff := &bytes.Buffer{}
+	w := NewWriter(buff)
+	w.EnableCompression("gzip, deflate")
+	h := GetDefaultHeaders(len(page))
+	h.Override("Content-Type", "text/html")
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(h))
+	n, err := w.WriteBody(page)
+	require.NoError(t, err)
+	assert.Equal(t, len(page), n)
+	respHeaders, body := readResponse(t, buff.Bytes())
+	assert.Equal(t, "gzip", respHeaders.Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", respHeaders.Get("Vary"))
+	assert.Equal(t, "", respHeaders.Get("Content-Length"))
+	gz, err := gzip.NewReader(bytes.NewReader(body))
+	require.NoError(t, err)
+	decoded, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	assert.Equal(t, page, decoded)
+	assert.Equal(t, strconv.Itoa(len(page)), h.Get("Content-Length"), "caller headers must not be modified")
+
+	// Test: Deflate chunked body
+	buff = &bytes.Buffer{}
+	w = NewWriter(buff)
+	w.EnableCompression("gzip;q=0.2, deflate")
+	h = GetDefaultHeaders(0)
+	h.Remove("Content-Length")
+	h.Override("Transfer-Encoding", "chunked")
+	h.Override("Content-Type", "application/json")
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(h))
+	_, err = w.WriteChunkedBody(page[:100])
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBody(page[100:])
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	respHeaders, body = readResponse(t, buff.Bytes())
+	assert.Equal(t, "deflate", respHeaders.Get("Content-Encoding"))
+	zr, err := zlib.NewReader(bytes.NewReader(body))
+	require.NoError(t, err)
+	decoded, err = io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, page, decoded)
+
+	// Test: Already compressed type is left alone
+	buff = &bytes.Buffer{}
+	w = NewWriter(buff)
+	w.EnableCompression("gzip")
+	h = GetDefaultHeaders(len(page))
+	h.Override("Content-Type", "video/mp4")
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(h))
+	_, err = w.WriteBody(page)
+	require.NoError(t, err)
+	respHeaders, body = readResponse(t, buff.Bytes())
+	assert.Equal(t, "", respHeaders.Get("Content-Encoding"))
+	assert.Equal(t, "", respHeaders.Get("Vary"))
+	assert.Equal(t, page, body)
+
+	// Test: Client without gzip still gets Vary
+	buff = &bytes.Buffer{}
+	w = NewWriter(buff)
+	w.EnableCompression("")
+	h = GetDefaultHeaders(len(page))
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(h))
+	_, err = w.WriteBody(page)
+	require.NoError(t, err)
+	respHeaders, body = readResponse(t, buff.Bytes())
+	assert.Equal(t, "", respHeaders.Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", respHeaders.Get("Vary"))
+	assert.Equal(t, page, body)
+
+	// Test: Bodies announcing trailers are not compressed
+	buff = &bytes.Buffer{}
+	w = NewWriter(buff)
+	w.EnableCompression("gzip")
+	h = headers.NewHeaders()
+	h.Set("Content-Type", "text/html")
+	h.Set("Transfer-Encoding", "chunked")
+	h.Set("Trailer", "X-Content-SHA256")
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(h))
+	_, err = w.WriteChunkedBody(page)
+	require.NoError(t, err)
+	require.NoError(t, w.SetTrailer("X-Content-SHA256", "0"))
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	assert.NotContains(t, buff.String(), "content-encoding")
+	assert.Contains(t, buff.String(), "\r\n\r\n"+strconv.FormatInt(int64(len(page)), 16)+"\r\n"+string(page)+"\r\n0\r\n")
+
+	// Test: Vary is not repeated when the handler already sent it
+	buff = &bytes.Buffer{}
+	w = NewWriter(buff)
+	w.EnableCompression("gzip")
+	h = GetDefaultHeaders(len(page))
+	h.Set("Vary", "Origin, accept-encoding")
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(h))
+	_, err = w.WriteBody(page)
+	require.NoError(t, err)
+	respHeaders, _ = readResponse(t, buff.Bytes())
+	assert.Equal(t, "gzip", respHeaders.Get("Content-Encoding"))
+	assert.Equal(t, "Origin, accept-encoding", respHeaders.Get("Vary"))
+
+	// Test: Small bodies are not compressed
+	buff = &bytes.Buffer{}
+	w = NewWriter(buff)
+	w.EnableCompression("gzip")
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(5)))
+	_, err = w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+	respHeaders, body = readResponse(t, buff.Bytes())
+	assert.Equal(t, "", respHeaders.Get("Content-Encoding"))
+	assert.Equal(t, "hello", string(body))
+}
@@ -0,0 +1,179 @@
+package response
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/xixotron/httpfromtcp/internal/headers"
+)
+
+// StrongETag formats tag as a strong entity tag, which promises the
+// representation is byte for byte identical while the tag stays the same.
+func StrongETag(tag string) string {
+	return `"` + tag + `"`
+}
+
+// WeakETag formats tag as a weak entity tag, which only promises the
+// representations are semantically equivalent.
+func WeakETag(tag string) string {
+	return `W/"` + tag + `"`
+}
+
+// ContentETag returns a strong entity tag derived from the body itself.
+func ContentETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return StrongETag(hex.EncodeToString(sum[:16]))
+}
+
+// CheckPreconditions evaluates the If-Match, If-Unmodified-Since,
+// If-None-Match and If-Modified-Since request headers against the current
+// validators of the target resource, in the order given by RFC 9110 section
+// 13.2.2. It returns StatusOK when the request should be processed normally,
+// StatusNotModified or StatusPreconditionFailed otherwise. An empty etag or a
+// zero lastModified means the resource has no such validator.
+//
+// Handlers for unsafe methods should call it before changing any state.
+func CheckPreconditions(method string, reqHeaders headers.Headers, etag string, lastModified time.Time) StatusCode {
+	lastModified = lastModified.Truncate(time.Second)
+	safe := method == "GET" || method == "HEAD"
+
+	if ifMatch := reqHeaders.Get("If-Match"); ifMatch != "" {
+		if !matchETag(ifMatch, etag, true) {
+			return StatusPreconditionFailed
+		}
+	} else if since, ok := parseHTTPDate(reqHeaders.Get("If-Unmodified-Since")); ok && !lastModified.IsZero() {
+		if lastModified.After(since) {
+			return StatusPreconditionFailed
+		}
+	}
+
+	if ifNoneMatch := reqHeaders.Get("If-None-Match"); ifNoneMatch != "" {
+		if matchETag(ifNoneMatch, etag, false) {
+			if safe {
+				return StatusNotModified
+			}
+			return StatusPreconditionFailed
+		}
+	} else if since, ok := parseHTTPDate(reqHeaders.Get("If-Modified-Since")); ok && safe && !lastModified.IsZero() {
+		if !lastModified.After(since) {
+			return StatusNotModified
+		}
+	}
+
+	return StatusOK
+}
+
+// EnablePreconditions makes the Writer evaluate the request's conditional
+// headers against the ETag and Last-Modified of a successful response when
+// its headers are written, replacing the response with 304 Not Modified or
+// 412 Precondition Failed as needed. Anything the handler writes to the body
+// afterwards is discarded. It must be called before WriteHeaders.
+//
+// Only GET and HEAD requests are checked this way. By the time the headers
+// are written, the handler of an unsafe method has already made its change,
+// so it must call CheckPreconditions itself before doing anything.
+func (w *Writer) EnablePreconditions(method string, reqHeaders headers.Headers) {
+	if method != "GET" && method != "HEAD" {
+		return
+	}
+	w.preconditionMethod = method
+	w.preconditionHeaders = reqHeaders
+}
+
+// applyPreconditions returns the status and headers to send in place of the
+// handler's when a precondition turns the response into a 304 or 412.
+func (w *Writer) applyPreconditions(h headers.Headers) (StatusCode, headers.Headers, bool) {
+	if w.preconditionHeaders == nil || w.statusCode < 200 || w.statusCode > 299 {
+		return w.statusCode, h, false
+	}
+
+	var lastModified time.Time
+	if value, ok := parseHTTPDate(h.Get("Last-Modified")); ok {
+		lastModified = value
+	}
+	statusCode := CheckPreconditions(w.preconditionMethod, w.preconditionHeaders, h.Get("ETag"), lastModified)
+	if statusCode == StatusOK {
+		return w.statusCode, h, false
+	}
+
+	out := headers.NewHeaders()
+	if statusCode == StatusNotModified {
+		// RFC 9110 section 15.4.5 lists what a 304 must repeat from the 200
+		for _, key := range []string{"ETag", "Last-Modified", "Cache-Control", "Expires", "Vary", "Content-Location", "Date"} {
+			if value := h.Get(key); value != "" {
+				out.Set(key, value)
+			}
+		}
+	} else {
+		out.Set("Content-Length", "0")
+	}
+	if connection := h.Get("Connection"); connection != "" {
+		out.Set("Connection", connection)
+	}
+	return statusCode, out, true
+}
+
+// matchETag reports whether etag is listed in the If-Match or If-None-Match
+// value list. "*" matches any current representation.
+func matchETag(list string, etag string, strong bool) bool {
+	if strings.TrimSpace(list) == "*" {
+		return etag != ""
+	}
+	if etag == "" {
+		return false
+	}
+	for _, candidate := range parseETagList(list) {
+		if strong && (strings.HasPrefix(candidate, "W/") || strings.HasPrefix(etag, "W/")) {
+			continue
+		}
+		if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// ETagMatches reports whether the entity tag given in an If-Range header
+// strongly matches etag.
+func ETagMatches(ifRange string, etag string) bool {
+	return matchETag(ifRange, etag, true) && strings.TrimSpace(ifRange) != "*"
+}
+
+// parseETagList splits a comma separated list of entity tags. Commas may
+// appear inside the quotes, so the list is scanned rather than split.
+func parseETagList(list string) []string {
+	var tags []string
+	for {
+		list = strings.TrimLeft(list, " \t,")
+		if list == "" {
+			return tags
+		}
+		start := 0
+		if strings.HasPrefix(list, "W/") {
+			start = 2
+		}
+		if len(list) <= start || list[start] != '"' {
+			return tags
+		}
+		end := strings.IndexByte(list[start+1:], '"')
+		if end == -1 {
+			return tags
+		}
+		end += start + 2
+		tags = append(tags, list[:end])
+		list = list[end:]
+	}
+}
+
+func parseHTTPDate(value string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+	date, err := time.Parse(TimeFormat, strings.TrimSpace(value))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return date, true
+}
@@ -0,0 +1,138 @@
+package response
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xixotron/httpfromtcp/internal/headers"
+)
+
+func TestETagHelpers(t *testing.T) {
+	assert.Equal(t, `"abc"`, StrongETag("abc"))
+	assert.Equal(t, `W/"abc"`, WeakETag("abc"))
+	assert.Equal(t, ContentETag([]byte("hello")), ContentETag([]byte("hello")))
+	assert.NotEqual(t, ContentETag([]byte("hello")), ContentETag([]byte("hello!")))
+	assert.True(t, strings.HasPrefix(ContentETag(nil), `"`))
+
+	// Test: Entity tag lists
+	assert.Equal(t, []string{`"a"`, `W/"b"`, `"c,d"`}, parseETagList(` "a", W/"b" ,"c,d"`))
+	assert.Empty(t, parseETagList(`garbage`))
+
+	// Test: If-Range uses strong comparison
+	assert.True(t, ETagMatches(`"a"`, `"a"`))
+	assert.False(t, ETagMatches(`W/"a"`, `"a"`))
+	assert.False(t, ETagMatches(`"a"`, `W/"a"`))
+	assert.False(t, ETagMatches(`*`, `"a"`))
+}
+
+func TestCheckPreconditions(t *testing.T) {
+	etag := `"v1"`
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	before := modified.Add(-time.Hour).Format(TimeFormat)
+	after := modified.Add(time.Hour).Format(TimeFormat)
+	check := func(method string, h headers.Headers) StatusCode {
+		return CheckPreconditions(method, h, etag, modified)
+	}
+
+	// Test: No preconditions
+	assert.Equal(t, StatusOK, check("GET", headers.Headers{}))
+
+	// Test: If-None-Match
+	assert.Equal(t, StatusNotModified, check("GET", headers.Headers{"if-none-match": `"v0", "v1"`}))
+	assert.Equal(t, StatusNotModified, check("HEAD", headers.Headers{"if-none-match": `W/"v1"`}))
+	assert.Equal(t, StatusNotModified, check("GET", headers.Headers{"if-none-match": `*`}))
+	assert.Equal(t, StatusOK, check("GET", headers.Headers{"if-none-match": `"v0"`}))
+	assert.Equal(t, StatusPreconditionFailed, check("PUT", headers.Headers{"if-none-match": `*`}))
+
+	// Test: If-Modified-Since
+	assert.Equal(t, StatusNotModified, check("GET", headers.Headers{"if-modified-since": modified.Format(TimeFormat)}))
+	assert.Equal(t, StatusNotModified, check("GET", headers.Headers{"if-modified-since": after}))
+	assert.Equal(t, StatusOK, check("GET", headers.Headers{"if-modified-since": before}))
+	assert.Equal(t, StatusOK, check("POST", headers.Headers{"if-modified-since": after}))
+	assert.Equal(t, StatusOK, check("GET", headers.Headers{"if-modified-since": "yesterday"}))
+
+	// Test: If-None-Match takes precedence over If-Modified-Since
+	assert.Equal(t, StatusOK, check("GET", headers.Headers{"if-none-match": `"v0"`, "if-modified-since": after}))
+
+	// Test: If-Match
+	assert.Equal(t, StatusOK, check("PUT", headers.Headers{"if-match": `"v1"`}))
+	assert.Equal(t, StatusOK, check("PUT", headers.Headers{"if-match": `*`}))
+	assert.Equal(t, StatusPreconditionFailed, check("PUT", headers.Headers{"if-match": `"v0"`}))
+	assert.Equal(t, StatusPreconditionFailed, check("PUT", headers.Headers{"if-match": `W/"v1"`}))
+	assert.Equal(t, StatusPreconditionFailed, CheckPreconditions("PUT", headers.Headers{"if-match": `*`}, "", time.Time{}))
+
+	// Test: If-Unmodified-Since
+	assert.Equal(t, StatusOK, check("PUT", headers.Headers{"if-unmodified-since": after}))
+	assert.Equal(t, StatusPreconditionFailed, check("PUT", headers.Headers{"if-unmodified-since": before}))
+
+	// Test: If-Match takes precedence over If-Unmodified-Since
+	assert.Equal(t, StatusOK, check("PUT", headers.Headers{"if-match": `"v1"`, "if-unmodified-since": before}))
+
+	// Test: If-Match is evaluated before If-None-Match
+	assert.Equal(t, StatusPreconditionFailed, check("GET", headers.Headers{"if-match": `"v0"`, "if-none-match": `"v1"`}))
+}
+
+func TestWriterPreconditions(t *testing.T) {
+	body := []byte("<h1>hello</h1>")
+	etag := ContentETag(body)
+	write := func(reqHeaders headers.Headers) string {
+		buff := &bytes.Buffer{}
+		w := NewWriter(buff)
+		w.EnablePreconditions("GET", reqHeaders)
+		h := GetDefaultHeaders(len(body))
+		h.Set("ETag", etag)
+		h.Set("Cache-Control", "max-age=60")
+		require.NoError(t, w.WriteStatusLine(StatusOK))
+		require.NoError(t, w.WriteHeaders(h))
+		n, err := w.WriteBody(body)
+		require.NoError(t, err)
+		assert.Equal(t, len(body), n)
+		return buff.String()
+	}
+
+	// Test: Fresh copy gets 304 without a body
+	resp := write(headers.Headers{"if-none-match": etag})
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 304 Not Modified\r\n"))
+	assert.Contains(t, resp, "etag: "+etag+"\r\n")
+	assert.Contains(t, resp, "cache-control: max-age=60\r\n")
+	assert.NotContains(t, resp, "content-length")
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\n"))
+
+	// Test: Failed If-Match gets 412
+	resp = write(headers.Headers{"if-match": `"other"`})
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 412 Precondition Failed\r\n"))
+	assert.Contains(t, resp, "content-length: 0\r\n")
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\n"))
+
+	// Test: Stale copy gets the full response
+	resp = write(headers.Headers{"if-none-match": `"stale"`})
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(resp, string(body)))
+
+	// Test: Unsafe methods are left to the handler
+	buff := &bytes.Buffer{}
+	w := NewWriter(buff)
+	w.EnablePreconditions("PUT", headers.Headers{"if-match": `"other"`})
+	h := GetDefaultHeaders(0)
+	h.Set("ETag", etag)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(h))
+	require.NoError(t, w.Flush())
+	assert.True(t, strings.HasPrefix(buff.String(), "HTTP/1.1 200 OK\r\n"))
+
+	// Test: Error responses are never replaced
+	buff = &bytes.Buffer{}
+	w = NewWriter(buff)
+	w.EnablePreconditions("GET", headers.Headers{"if-none-match": "*"})
+	h = GetDefaultHeaders(0)
+	h.Set("ETag", etag)
+	require.NoError(t, w.WriteStatusLine(StatusNotFound))
+	require.NoError(t, w.WriteHeaders(h))
+	require.NoError(t, w.Flush())
+	assert.True(t, strings.HasPrefix(buff.String(), "HTTP/1.1 404 Not Found\r\n"))
+}
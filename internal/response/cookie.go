@@ -0,0 +1,172 @@
+package response
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xixotron/httpfromtcp/internal/headers"
+)
+
+// ErrInvalidCookie is returned for a cookie that cannot be sent in a
+// Set-Cookie header as it is.
+var ErrInvalidCookie = errors.New("invalid cookie")
+
+// SameSite controls when a browser sends a cookie along with requests made
+// from other sites.
+type SameSite int
+
+const (
+	// SameSiteDefault leaves the attribute out, letting the browser decide.
+	SameSiteDefault SameSite = iota
+	SameSiteLax
+	SameSiteStrict
+	SameSiteNone
+)
+
+// Cookie is a cookie to set with a Set-Cookie header (RFC 6265 section 4.1).
+type Cookie struct {
+	Name  string
+	Value string
+
+	// Expires is left out when zero.
+	Expires time.Time
+	// MaxAge is the lifetime in seconds. Zero leaves the attribute out, a
+	// negative value sends Max-Age=0, which deletes the cookie.
+	MaxAge int
+
+	Domain   string
+	Path     string
+	Secure   bool
+	HttpOnly bool
+	SameSite SameSite
+	// Partitioned keeps the cookie in storage separate for each top-level
+	// site (CHIPS). It requires Secure.
+	Partitioned bool
+}
+
+// HeaderValue returns the value of the Set-Cookie header for c, or an error
+// wrapping ErrInvalidCookie when c is invalid.
+func (c *Cookie) HeaderValue() (string, error) {
+	if !headers.IsToken(c.Name) {
+		return "", fmt.Errorf("%w: name %q", ErrInvalidCookie, c.Name)
+	}
+	if !headers.IsCookieValue(c.Value) {
+		return "", fmt.Errorf("%w: value of %s", ErrInvalidCookie, c.Name)
+	}
+
+	var b strings.Builder
+	b.WriteString(c.Name + "=" + c.Value)
+	if !c.Expires.IsZero() {
+		b.WriteString("; Expires=" + c.Expires.UTC().Format(TimeFormat))
+	}
+	if c.MaxAge > 0 {
+		b.WriteString("; Max-Age=" + strconv.Itoa(c.MaxAge))
+	} else if c.MaxAge < 0 {
+		b.WriteString("; Max-Age=0")
+	}
+	if c.Domain != "" {
+		domain := strings.TrimPrefix(c.Domain, ".")
+		if !validCookieDomain(domain) {
+			return "", fmt.Errorf("%w: domain %q", ErrInvalidCookie, c.Domain)
+		}
+		b.WriteString("; Domain=" + domain)
+	}
+	if c.Path != "" {
+		if !validCookiePath(c.Path) {
+			return "", fmt.Errorf("%w: path %q", ErrInvalidCookie, c.Path)
+		}
+		b.WriteString("; Path=" + c.Path)
+	}
+	if c.Secure {
+		b.WriteString("; Secure")
+	}
+	if c.HttpOnly {
+		b.WriteString("; HttpOnly")
+	}
+	switch c.SameSite {
+	case SameSiteDefault:
+	case SameSiteLax:
+		b.WriteString("; SameSite=Lax")
+	case SameSiteStrict:
+		b.WriteString("; SameSite=Strict")
+	case SameSiteNone:
+		// browsers drop SameSite=None cookies that are not Secure
+		if !c.Secure {
+			return "", fmt.Errorf("%w: SameSite=None without Secure", ErrInvalidCookie)
+		}
+		b.WriteString("; SameSite=None")
+	default:
+		return "", fmt.Errorf("%w: SameSite %d", ErrInvalidCookie, c.SameSite)
+	}
+	if c.Partitioned {
+		if !c.Secure {
+			return "", fmt.Errorf("%w: Partitioned without Secure", ErrInvalidCookie)
+		}
+		b.WriteString("; Partitioned")
+	}
+	return b.String(), nil
+}
+
+// SetCookie adds a Set-Cookie header for c to the response. It must be called
+// before WriteHeaders; every cookie goes out on a line of its own, since
+// Set-Cookie values cannot be joined with commas like other fields.
+func (w *Writer) SetCookie(c *Cookie) error {
+	if w.state != StateStatusLine && w.state != StateHeaders {
+		return w.checkState("set cookie", StateHeaders)
+	}
+	value, err := c.HeaderValue()
+	if err != nil {
+		return err
+	}
+	w.cookies = append(w.cookies, value)
+	return nil
+}
+
+// AddSetCookie adds a Set-Cookie header with a value that is already
+// formatted, such as one relayed from another server. Like SetCookie, it must
+// be called before WriteHeaders.
+func (w *Writer) AddSetCookie(value string) error {
+	if w.state != StateStatusLine && w.state != StateHeaders {
+		return w.checkState("set cookie", StateHeaders)
+	}
+	if err := headers.ValidateField("Set-Cookie", value); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidCookie, err)
+	}
+	w.cookies = append(w.cookies, value)
+	return nil
+}
+
+// validCookieDomain accepts host names made of letters, digits, hyphens and
+// dots, which includes IPv4 addresses.
+func validCookieDomain(domain string) bool {
+	if domain == "" || len(domain) > 255 {
+		return false
+	}
+	for label := range strings.SplitSeq(domain, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for i := 0; i < len(label); i++ {
+			c := label[i]
+			if !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') && c != '-' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// validCookiePath rejects control characters and semicolons, which would end
+// the attribute.
+func validCookiePath(path string) bool {
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if c < 0x20 || c == 0x7f || c == ';' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,85 @@
+package response
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCookieHeaderValue(t *testing.T) {
+	// Test: Every attribute
+	c := &Cookie{
+		Name:        "session",
+		Value:       "abc123",
+		Expires:     time.Date(2030, time.January, 2, 3, 4, 5, 0, time.FixedZone("CET", 3600)),
+		MaxAge:      3600,
+		Domain:      ".example.com",
+		Path:        "/app",
+		Secure:      true,
+		HttpOnly:    true,
+		SameSite:    SameSiteNone,
+		Partitioned: true,
+	}
+	value, err := c.HeaderValue()
+	require.NoError(t, err)
+	assert.Equal(t, "session=abc123; Expires=Wed, 02 Jan 2030 02:04:05 GMT; Max-Age=3600; "+
+		"Domain=example.com; Path=/app; Secure; HttpOnly; SameSite=None; Partitioned", value)
+
+	// Test: Deleting a cookie
+	value, err = (&Cookie{Name: "session", MaxAge: -1, SameSite: SameSiteLax}).HeaderValue()
+	require.NoError(t, err)
+	assert.Equal(t, "session=; Max-Age=0; SameSite=Lax", value)
+
+	// Test: Invalid cookies
+	for _, c := range []*Cookie{
+		{Name: "", Value: "x"},
+		{Name: "bad name", Value: "x"},
+		{Name: "a", Value: "has space"},
+		{Name: "a", Value: "semi;colon"},
+		{Name: "a", Value: "x", Domain: "exa mple.com"},
+		{Name: "a", Value: "x", Domain: "-bad.com"},
+		{Name: "a", Value: "x", Path: "/a;Secure"},
+		{Name: "a", Value: "x", SameSite: SameSiteNone},
+		{Name: "a", Value: "x", Partitioned: true},
+		{Name: "a", Value: "x", SameSite: SameSite(9)},
+	} {
+		_, err := c.HeaderValue()
+		require.ErrorIs(t, err, ErrInvalidCookie, "%+v", c)
+	}
+	_, err = (&Cookie{Name: "a", Value: `"quoted"`, SameSite: SameSiteStrict}).HeaderValue()
+	require.NoError(t, err)
+}
+
+func TestWriterSetCookie(t *testing.T) {
+	// Test: Each cookie on a line of its own
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.SetCookie(&Cookie{Name: "a", Value: "1", Expires: time.Date(2030, time.January, 2, 0, 0, 0, 0, time.UTC)}))
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.SetCookie(&Cookie{Name: "b", Value: "2", HttpOnly: true}))
+	require.ErrorIs(t, w.SetCookie(&Cookie{Name: "c", Value: "bad value"}), ErrInvalidCookie)
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(0)))
+	_, err := w.WriteBody(nil)
+	require.NoError(t, err)
+	assert.Contains(t, buf.String(), "\r\nset-cookie: a=1; Expires=Wed, 02 Jan 2030 00:00:00 GMT\r\n")
+	assert.Contains(t, buf.String(), "\r\nset-cookie: b=2; HttpOnly\r\n")
+	assert.NotContains(t, buf.String(), "c=")
+
+	// Test: Too late once the headers are sent
+	require.ErrorIs(t, w.SetCookie(&Cookie{Name: "d", Value: "4"}), ErrHeadersAlreadyWritten)
+	require.ErrorIs(t, w.AddSetCookie("d=4"), ErrHeadersAlreadyWritten)
+
+	// Test: Preformatted values are sent as they are, but cannot inject lines
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.AddSetCookie("e=5; Path=/; Expires=Wed, 02 Jan 2030 00:00:00 GMT"))
+	require.ErrorIs(t, w.AddSetCookie("f=6\r\nX-Evil: 1"), ErrInvalidCookie)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(0)))
+	require.NoError(t, w.Flush())
+	assert.Contains(t, buf.String(), "\r\nset-cookie: e=5; Path=/; Expires=Wed, 02 Jan 2030 00:00:00 GMT\r\n")
+	assert.NotContains(t, buf.String(), "X-Evil")
+}
@@ -10,30 +10,118 @@ import (
 type StatusCode int
 
 const (
+	StatusSwitchingProtocols  StatusCode = 101
 	StatusOK                  StatusCode = 200
+	StatusPartialContent      StatusCode = 206
+	StatusMovedPermanently    StatusCode = 301
+	StatusNotModified         StatusCode = 304
 	StatusBadRequest          StatusCode = 400
+	StatusForbidden           StatusCode = 403
+	StatusNotFound            StatusCode = 404
+	StatusMethodNotAllowed    StatusCode = 405
+	StatusPreconditionFailed  StatusCode = 412
+	StatusContentTooLarge     StatusCode = 413
+	StatusUnsupportedMedia    StatusCode = 415
+	StatusRangeNotSatisfiable StatusCode = 416
+	StatusMisdirectedRequest  StatusCode = 421
+	StatusUpgradeRequired     StatusCode = 426
 	StatusInternalServerError StatusCode = 500
+	StatusNotImplemented      StatusCode = 501
+	StatusBadGateway          StatusCode = 502
+	StatusServiceUnavailable  StatusCode = 503
 )
 
 const httpVersion = "HTTP/1.1"
 
-func getStatusLine(statusCode StatusCode) (statusLine string) {
+// TimeFormat is the IMF-fixdate layout used by Date, Last-Modified and the
+// other HTTP date fields. Times must be in UTC before formatting.
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// getStatusLine returns the status line for statusCode, with reason as the
+// reason phrase, or the usual one for the code when reason is empty.
+func getStatusLine(statusCode StatusCode, reason string) (statusLine string) {
 	var sb strings.Builder
 
 	sb.WriteString(fmt.Sprintf("%s %d ", httpVersion, statusCode))
+	if reason == "" {
+		reason = statusText(statusCode)
+	}
+	sb.WriteString(reason)
+	sb.WriteString("\r\n")
+	return sb.String()
+}
 
+func statusText(statusCode StatusCode) string {
 	switch statusCode {
+	case StatusSwitchingProtocols:
+		return "Switching Protocols"
 	case StatusOK:
-		sb.WriteString("OK")
+		return "OK"
+	case StatusPartialContent:
+		return "Partial Content"
+	case StatusMovedPermanently:
+		return "Moved Permanently"
+	case StatusNotModified:
+		return "Not Modified"
 	case StatusBadRequest:
-		sb.WriteString("Bad Request")
+		return "Bad Request"
+	case StatusForbidden:
+		return "Forbidden"
+	case StatusNotFound:
+		return "Not Found"
+	case StatusMethodNotAllowed:
+		return "Method Not Allowed"
+	case StatusPreconditionFailed:
+		return "Precondition Failed"
+	case StatusContentTooLarge:
+		return "Content Too Large"
+	case StatusUnsupportedMedia:
+		return "Unsupported Media Type"
+	case StatusRangeNotSatisfiable:
+		return "Range Not Satisfiable"
+	case StatusMisdirectedRequest:
+		return "Misdirected Request"
+	case StatusUpgradeRequired:
+		return "Upgrade Required"
 	case StatusInternalServerError:
-		sb.WriteString("Internal Server Error")
-	default:
-		sb.WriteString("Unknown Status")
+		return "Internal Server Error"
+	case StatusNotImplemented:
+		return "Not Implemented"
+	case StatusBadGateway:
+		return "Bad Gateway"
+	case StatusServiceUnavailable:
+		return "Service Unavailable"
 	}
-	sb.WriteString("\r\n")
-	return sb.String()
+	if text, ok := otherStatusText[statusCode]; ok {
+		return text
+	}
+	return "Unknown Status"
+}
+
+// otherStatusText holds the reason phrases of common codes the server itself
+// does not send, for handlers that answer with a bare code.
+var otherStatusText = map[StatusCode]string{
+	100: "Continue",
+	201: "Created",
+	202: "Accepted",
+	204: "No Content",
+	302: "Found",
+	303: "See Other",
+	307: "Temporary Redirect",
+	308: "Permanent Redirect",
+	401: "Unauthorized",
+	406: "Not Acceptable",
+	408: "Request Timeout",
+	409: "Conflict",
+	410: "Gone",
+	411: "Length Required",
+	414: "URI Too Long",
+	418: "I'm a teapot",
+	422: "Unprocessable Content",
+	428: "Precondition Required",
+	429: "Too Many Requests",
+	504: "Gateway Timeout",
+	505: "HTTP Version Not Supported",
 }
 
 func GetDefaultHeaders(contentLen int) headers.Headers {
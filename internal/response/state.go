@@ -0,0 +1,101 @@
+package response
+
+import (
+	"errors"
+	"fmt"
+)
+
+// State is how far a Writer got through its response. The methods of a
+// Writer must be called in order: WriteStatusLine, WriteHeaders, then the
+// body.
+type State int
+
+const (
+	StateStatusLine State = iota
+	StateHeaders
+	StateBody
+	StateDone
+	StateHijacked
+)
+
+func (s State) String() string {
+	switch s {
+	case StateStatusLine:
+		return "status line"
+	case StateHeaders:
+		return "headers"
+	case StateBody:
+		return "body"
+	case StateDone:
+		return "done"
+	case StateHijacked:
+		return "hijacked"
+	default:
+		return fmt.Sprintf("State(%d)", int(s))
+	}
+}
+
+var (
+	// ErrStatusAlreadyWritten is returned by WriteStatusLine once the status
+	// was set.
+	ErrStatusAlreadyWritten = errors.New("status line already written")
+	// ErrStatusNotWritten is returned by WriteHeaders before WriteStatusLine.
+	ErrStatusNotWritten = errors.New("status line not written yet")
+	// ErrHeadersAlreadyWritten is returned by WriteHeaders once the headers
+	// were sent.
+	ErrHeadersAlreadyWritten = errors.New("headers already written")
+	// ErrHeadersNotWritten is returned for body writes before WriteHeaders.
+	ErrHeadersNotWritten = errors.New("headers not written yet")
+	// ErrBodyAfterDone is returned for body writes once the response is
+	// complete.
+	ErrBodyAfterDone = errors.New("response already complete")
+	// ErrChunkedNotEnabled is returned for chunks and trailers on a response
+	// whose headers did not ask for chunked Transfer-Encoding, where they
+	// would end up in the body as-is.
+	ErrChunkedNotEnabled = errors.New("response is not chunked")
+	// ErrContentLengthExceeded is returned for body bytes beyond the length
+	// announced in the Content-Length header.
+	ErrContentLengthExceeded = errors.New("body longer than Content-Length")
+	// ErrHijacked is returned for any use of a Writer after Hijack.
+	ErrHijacked = errors.New("connection hijacked")
+)
+
+// State returns how far the response got.
+func (w *Writer) State() State {
+	return w.state
+}
+
+// checkState returns the error for calling op when the writer is not in the
+// state want.
+func (w *Writer) checkState(op string, want State) error {
+	if w.state == want {
+		return nil
+	}
+	var err error
+	switch {
+	case w.state == StateHijacked:
+		err = ErrHijacked
+	case w.state < want && want == StateHeaders:
+		err = ErrStatusNotWritten
+	case w.state < want:
+		err = ErrHeadersNotWritten
+	case want == StateStatusLine:
+		err = ErrStatusAlreadyWritten
+	case want == StateHeaders:
+		err = ErrHeadersAlreadyWritten
+	default:
+		err = ErrBodyAfterDone
+	}
+	return fmt.Errorf("%s: %w", op, err)
+}
+
+// checkChunked is checkState for the methods only valid on chunked bodies.
+func (w *Writer) checkChunked(op string) error {
+	if err := w.checkState(op, StateBody); err != nil {
+		return err
+	}
+	if !w.chunked {
+		return fmt.Errorf("%s: %w", op, ErrChunkedNotEnabled)
+	}
+	return nil
+}
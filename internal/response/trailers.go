@@ -0,0 +1,125 @@
+package response
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/xixotron/httpfromtcp/internal/headers"
+)
+
+var (
+	// ErrTrailerNotDeclared is returned for a trailer field the Trailer
+	// header of the response did not announce.
+	ErrTrailerNotDeclared = errors.New("trailer field not declared in Trailer header")
+	// ErrTrailerProhibited is returned for fields that must not be sent as
+	// trailers, because recipients need them before the body.
+	ErrTrailerProhibited = errors.New("field not allowed in trailers")
+)
+
+// prohibitedTrailers are the fields RFC 9110 section 6.5.1 forbids in
+// trailers: message framing, routing, request modifiers, authentication,
+// response control data and content processing.
+var prohibitedTrailers = map[string]bool{
+	"transfer-encoding":   true,
+	"content-length":      true,
+	"trailer":             true,
+	"host":                true,
+	"cache-control":       true,
+	"expect":              true,
+	"max-forwards":        true,
+	"pragma":              true,
+	"range":               true,
+	"te":                  true,
+	"authorization":       true,
+	"proxy-authenticate":  true,
+	"proxy-authorization": true,
+	"www-authenticate":    true,
+	"set-cookie":          true,
+	"cookie":              true,
+	"age":                 true,
+	"date":                true,
+	"expires":             true,
+	"location":            true,
+	"retry-after":         true,
+	"vary":                true,
+	"warning":             true,
+	"content-encoding":    true,
+	"content-type":        true,
+	"content-range":       true,
+}
+
+// TrailerAllowed reports whether name may be sent as a trailer field.
+func TrailerAllowed(name string) bool {
+	return !prohibitedTrailers[strings.ToLower(name)]
+}
+
+// parseDeclaredTrailers returns the field names listed in the Trailer
+// header, lowercased.
+func parseDeclaredTrailers(h headers.Headers) (map[string]bool, error) {
+	declared := map[string]bool{}
+	for name := range strings.SplitSeq(h.Get("Trailer"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if prohibitedTrailers[name] {
+			return nil, fmt.Errorf("%w: %s", ErrTrailerProhibited, name)
+		}
+		declared[name] = true
+	}
+	return declared, nil
+}
+
+// checkTrailer reports whether name may be sent as a trailer of this
+// response.
+func (w *Writer) checkTrailer(name string) error {
+	key := strings.ToLower(name)
+	if prohibitedTrailers[key] {
+		return fmt.Errorf("%w: %s", ErrTrailerProhibited, name)
+	}
+	if !w.declaredTrailers[key] {
+		return fmt.Errorf("%w: %s", ErrTrailerNotDeclared, name)
+	}
+	return nil
+}
+
+// SetTrailer sets the value of a trailer field while the body is being
+// written, such as a checksum computed over the chunks sent so far. The field
+// must have been declared in the Trailer header. The values are sent after
+// the last chunk, by WriteChunkedBodyDone, WriteTrailers or ReadFrom.
+func (w *Writer) SetTrailer(name, value string) error {
+	if err := w.checkChunked("set trailer"); err != nil {
+		return err
+	}
+	if err := headers.ValidateField(name, value); err != nil {
+		return fmt.Errorf("set trailer: %w", err)
+	}
+	if err := w.checkTrailer(name); err != nil {
+		return err
+	}
+	if w.trailers == nil {
+		w.trailers = headers.NewHeaders()
+	}
+	w.trailers.Override(name, value)
+	return nil
+}
+
+// writeLastChunk ends a chunked body with the zero-length chunk, followed by
+// the trailers set so far, and returns the number of bytes written. The
+// trailers were validated by SetTrailer or WriteTrailers on their way in.
+func (w *Writer) writeLastChunk() (int, error) {
+	n, err := w.writer.Write([]byte("0\r\n"))
+	if err != nil {
+		return n, err
+	}
+	for key, value := range w.trailers {
+		m, err := fmt.Fprintf(w.writer, "%s: %s\r\n", key, value)
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	m, err := w.writer.Write([]byte("\r\n"))
+	return n + m, err
+}
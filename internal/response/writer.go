@@ -1,102 +1,475 @@
 package response
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
 	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
 
 	"github.com/xixotron/httpfromtcp/internal/headers"
+	"github.com/xixotron/httpfromtcp/internal/request"
 )
 
-type writerState int
+var _ io.ReaderFrom = (*Writer)(nil)
 
-const (
-	writerStateStatusLine writerState = iota
-	writerStateWriteHeaders
-	writerStateWriteBody
-	writerStateDone
-)
+// DefaultBufferSize is the buffer size used by NewWriter, enough for the
+// status line, headers and body of a small response to go out in a single
+// write.
+const DefaultBufferSize = 4096
 
 type Writer struct {
-	state  writerState
-	writer io.Writer
+	state State
+	// writer is where the response goes, normally buf; it is swapped for
+	// io.Discard once a body must be dropped
+	writer     io.Writer
+	buf        *bufio.Writer
+	conn       io.Writer
+	statusCode StatusCode
+	// reason is the reason phrase to send, empty for the usual one
+	reason  string
+	chunked bool
+	// contentLength is the body length the headers announced, or -1 when
+	// the body is not sent as-is with a Content-Length; written counts the
+	// body bytes sent against it
+	contentLength int64
+	written       int64
+
+	// declaredTrailers holds the lowercased names listed in the Trailer
+	// header, the only fields trailers may carry
+	declaredTrailers map[string]bool
+	trailers         headers.Headers
+	// cookies are the Set-Cookie values, each sent on a line of its own
+	cookies []string
+
+	compression    bool
+	acceptEncoding string
+	encoder        io.WriteCloser
+
+	preconditionMethod  string
+	preconditionHeaders headers.Headers
+
+	// reader is where the server reads requests from, its unread bytes
+	// are handed over by Hijack
+	reader *request.Reader
+	// closeConn is set when the response cannot be followed by another one
+	// on the same connection
+	closeConn bool
+	// headRequest is set for responses to HEAD, whose body is counted but
+	// never sent
+	headRequest bool
 }
 
 func NewWriter(w io.Writer) *Writer {
+	return NewWriterSize(w, DefaultBufferSize)
+}
+
+// NewWriterSize returns a Writer that buffers up to size bytes before writing
+// to w. The buffer is flushed when the response is complete, or explicitly
+// with Flush.
+func NewWriterSize(w io.Writer, size int) *Writer {
+	buf := bufio.NewWriterSize(w, size)
 	return &Writer{
-		state:  writerStateStatusLine,
-		writer: w,
+		state:         StateStatusLine,
+		writer:        buf,
+		buf:           buf,
+		conn:          w,
+		contentLength: -1,
+	}
+}
+
+// AttachReader tells the Writer which reader the server parses requests
+// from, so Hijack can return the bytes it already read past the request.
+func (w *Writer) AttachReader(r *request.Reader) {
+	w.reader = r
+}
+
+// SetRequestMethod tells the Writer the method of the request it answers.
+// The response to a HEAD request ends with its headers: whatever body the
+// handler writes is counted against Content-Length but not sent.
+func (w *Writer) SetRequestMethod(method string) {
+	w.headRequest = method == "HEAD"
+}
+
+// Flush sends everything buffered so far to the client, including data held
+// back by the compressor. Handlers streaming a body call it whenever the data
+// written so far should reach the client without waiting for more.
+func (w *Writer) Flush() error {
+	if flusher, ok := w.encoder.(interface{ Flush() error }); ok {
+		if err := flusher.Flush(); err != nil {
+			return err
+		}
 	}
+	return w.buf.Flush()
 }
 
+// WriteStatusLine sets the response status. The status line itself is sent
+// together with the headers, since preconditions may still replace it.
 func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
-	if w.state != writerStateStatusLine {
-		return fmt.Errorf("cannot write status line in state %d", w.state)
+	if err := w.checkState("write status line", StateStatusLine); err != nil {
+		return err
 	}
-	defer func() { w.state = writerStateWriteHeaders }()
+	defer func() { w.state = StateHeaders }()
 
-	_, err := fmt.Fprint(w.writer, getStatusLine(statusCode))
-	return err
+	w.statusCode = statusCode
+	return nil
+}
+
+// WriteStatusLineReason is WriteStatusLine with a reason phrase of the
+// caller's choosing, such as the one a proxy got from its upstream. An empty
+// reason sends the usual phrase for the code.
+func (w *Writer) WriteStatusLineReason(statusCode StatusCode, reason string) error {
+	if !validReason(reason) {
+		return fmt.Errorf("write status line: invalid reason phrase %q", reason)
+	}
+	if err := w.WriteStatusLine(statusCode); err != nil {
+		return err
+	}
+	w.reason = reason
+	return nil
+}
+
+// validReason reports whether reason is a reason-phrase (RFC 9112 section
+// 4): tabs, spaces, visible characters and obs-text.
+func validReason(reason string) bool {
+	for i := 0; i < len(reason); i++ {
+		c := reason[i]
+		if c != '\t' && (c < 0x20 || c == 0x7f) {
+			return false
+		}
+	}
+	return true
 }
 
 func (w *Writer) WriteHeaders(headers headers.Headers) error {
-	if w.state != writerStateWriteHeaders {
-		return fmt.Errorf("cannot write headers in state %d", w.state)
+	if err := w.checkState("write headers", StateHeaders); err != nil {
+		return err
+	}
+	if err := headers.Validate(); err != nil {
+		return err
 	}
-	defer func() { w.state = writerStateWriteBody }()
+	requested := headers
+	statusCode, headers, replaced := w.applyPreconditions(headers)
+	if replaced {
+		w.statusCode = statusCode
+		w.reason = ""
+	} else {
+		var err error
+		headers, err = w.prepareCompression(headers)
+		if err != nil {
+			return err
+		}
+	}
+	// a replaced response still accepts the body the handler meant to send,
+	// framed the way it asked for
+	framing := headers
+	if replaced {
+		framing = requested
+	}
+	w.chunked = strings.EqualFold(framing.Get("Transfer-Encoding"), "chunked")
+	declared, err := parseDeclaredTrailers(framing)
+	if err != nil {
+		return err
+	}
+	w.declaredTrailers = declared
+	// a body without Content-Length or chunked framing ends when the
+	// connection does
+	_, hasContentLength := headers["content-length"]
+	sentChunked := strings.EqualFold(headers.Get("Transfer-Encoding"), "chunked")
+	w.closeConn = headers.HasToken("Connection", "close") ||
+		(!w.headRequest && bodyAllowed(w.statusCode) && !hasContentLength && !sentChunked)
+	w.contentLength = -1
+	if hasContentLength && !replaced && w.encoder == nil && !sentChunked && bodyAllowed(w.statusCode) {
+		length, err := strconv.ParseInt(headers.Get("Content-Length"), 10, 64)
+		if err != nil || length < 0 {
+			return fmt.Errorf("write headers: invalid Content-Length %q", headers.Get("Content-Length"))
+		}
+		w.contentLength = length
+	}
+	defer func() { w.state = StateBody }()
 
+	if _, err := fmt.Fprint(w.writer, getStatusLine(w.statusCode, w.reason)); err != nil {
+		return err
+	}
 	for key, value := range headers {
 		_, err := fmt.Fprintf(w.writer, "%s: %s\r\n", key, value)
 		if err != nil {
 			return err
 		}
 	}
-	_, err := fmt.Fprint(w.writer, "\r\n")
+	for _, cookie := range w.cookies {
+		if _, err := fmt.Fprintf(w.writer, "set-cookie: %s\r\n", cookie); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprint(w.writer, "\r\n")
+	if replaced || w.headRequest {
+		// the handler does not know its response was replaced, or writes the
+		// same body it would for GET; either must not reach the client
+		w.writer = io.Discard
+	}
 	return err
 }
 
+// WriteBody sends p as the whole body and completes the response. A body
+// longer than the Content-Length header is refused with
+// ErrContentLengthExceeded.
 func (w *Writer) WriteBody(p []byte) (int, error) {
-	if w.state != writerStateWriteBody {
-		return 0, fmt.Errorf("cannot write body in state %d", w.state)
+	if err := w.checkState("write body", StateBody); err != nil {
+		return 0, err
+	}
+	defer w.finishBody()
+
+	if w.encoder != nil {
+		if _, err := w.encoder.Write(p); err != nil {
+			return 0, err
+		}
+		if err := w.closeEncoder(); err != nil {
+			return 0, err
+		}
+		if _, err := w.writeLastChunk(); err != nil {
+			return 0, err
+		}
+		return len(p), w.Flush()
 	}
-	defer func() { w.state = writerStateDone }()
+	if w.contentLength >= 0 && int64(len(p)) > w.contentLength-w.written {
+		// nothing of it is sent, the client sees a truncated body on a
+		// closed connection rather than bytes it would take for the start
+		// of the next response
+		w.closeConn = true
+		return 0, fmt.Errorf("write body: %w: %d bytes for Content-Length %d",
+			ErrContentLengthExceeded, len(p), w.contentLength)
+	}
+	n, err := w.writer.Write(p)
+	w.written += int64(n)
+	if err != nil {
+		return n, err
+	}
+	return n, w.Flush()
+}
 
-	return w.writer.Write(p)
+// finishBody completes the response. A fixed-length body that came up short
+// leaves the client waiting for the rest, so the connection is closed
+// instead of carrying another response. The body of a HEAD response is never
+// sent, so it is complete whatever its length.
+func (w *Writer) finishBody() {
+	w.state = StateDone
+	if !w.headRequest && w.contentLength >= 0 && w.written < w.contentLength {
+		log.Printf("response body is %d bytes, shorter than its Content-Length %d; closing connection",
+			w.written, w.contentLength)
+		w.closeConn = true
+	}
 }
 
+// ReadFrom copies r into the body until EOF and completes the response, like
+// WriteBody does for a body that is already in memory. With a Content-Length,
+// at most that many bytes are copied, and ErrContentLengthExceeded is
+// returned when r holds more.
+func (w *Writer) ReadFrom(r io.Reader) (int64, error) {
+	if err := w.checkState("write body", StateBody); err != nil {
+		return 0, err
+	}
+	defer w.finishBody()
+
+	var n int64
+	var err error
+	switch {
+	case w.encoder != nil:
+		n, err = io.Copy(w.encoder, r)
+	case w.chunked:
+		n, err = io.Copy(&chunkWriter{w: w}, r)
+	case w.contentLength >= 0:
+		n, err = w.copyFixedLength(r)
+	default:
+		n, err = w.copyBody(r)
+	}
+	if err != nil {
+		return n, err
+	}
+	if err := w.closeEncoder(); err != nil {
+		return n, err
+	}
+	if w.chunked {
+		if _, err := w.writeLastChunk(); err != nil {
+			return n, err
+		}
+	}
+	return n, w.Flush()
+}
+
+// copyFixedLength copies the rest of a body announced with Content-Length,
+// then checks that src has nothing left.
+func (w *Writer) copyFixedLength(src io.Reader) (int64, error) {
+	remaining := w.contentLength - w.written
+	// a reader already limited to what fits is passed on as it is, so
+	// copyBody can still hand it to sendfile
+	limited, ok := src.(*io.LimitedReader)
+	if !ok || limited.N > remaining {
+		limited = &io.LimitedReader{R: src, N: remaining}
+	}
+	n, err := w.copyBody(limited)
+	w.written += n
+	if err != nil || n < remaining {
+		return n, err
+	}
+	var extra [1]byte
+	if m, _ := src.Read(extra[:]); m > 0 {
+		w.closeConn = true
+		return n, fmt.Errorf("write body: %w: Content-Length %d", ErrContentLengthExceeded, w.contentLength)
+	}
+	return n, nil
+}
+
+// copyBody copies a body sent as-is. When the connection is a TCP socket the
+// buffer is flushed and the copy left to net.TCPConn.ReadFrom, which moves the
+// bytes of an *os.File (or an io.LimitedReader around one, as used for
+// ranges) with sendfile(2) or splice(2), without them ever passing through
+// user space.
+func (w *Writer) copyBody(src io.Reader) (int64, error) {
+	conn, ok := w.conn.(*net.TCPConn)
+	if !ok || w.writer != io.Writer(w.buf) {
+		return io.Copy(w.writer, src)
+	}
+	if err := w.buf.Flush(); err != nil {
+		return 0, err
+	}
+	return conn.ReadFrom(src)
+}
+
+// Hijack hands the underlying connection over to the caller, after sending
+// anything still buffered. It also returns the bytes the server already read
+// from the connection past the current request, which belong to whatever
+// protocol the caller speaks next. The Writer cannot be used afterwards, and
+// the server neither closes the connection nor reads further requests from
+// it; that becomes the caller's job.
+func (w *Writer) Hijack() (net.Conn, []byte, error) {
+	if w.state == StateHijacked {
+		return nil, nil, fmt.Errorf("hijack: %w", ErrHijacked)
+	}
+	conn, ok := w.conn.(net.Conn)
+	if !ok {
+		return nil, nil, fmt.Errorf("cannot hijack a %T", w.conn)
+	}
+	if err := w.Flush(); err != nil {
+		return nil, nil, err
+	}
+	w.state = StateHijacked
+
+	var buffered []byte
+	if w.reader != nil {
+		buffered = bytes.Clone(w.reader.Buffered())
+	}
+	return conn, buffered, nil
+}
+
+// KeepAlive reports whether the connection can carry another request after
+// this response: it must be complete, delimited by Content-Length or chunked
+// encoding, and must not have asked for the connection to be closed. The
+// response to a HEAD request is complete once its headers are sent.
+func (w *Writer) KeepAlive() bool {
+	switch w.state {
+	case StateDone:
+		return !w.closeConn
+	case StateBody:
+		return w.headRequest && !w.closeConn
+	default:
+		return false
+	}
+}
+
+// Hijacked reports whether Hijack took over the connection.
+func (w *Writer) Hijacked() bool {
+	return w.state == StateHijacked
+}
+
+// WriteChunkedBody sends p as one chunk. The headers must have set
+// Transfer-Encoding: chunked. An empty p sends nothing; the body only ends
+// with WriteChunkedBodyDone or WriteTrailers. ChunkedWriter leaves the
+// framing to the Writer instead.
 func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
-	if w.state != writerStateWriteBody {
-		return 0, fmt.Errorf("cannot write body in state %d", w.state)
+	if err := w.checkChunked("write chunk"); err != nil {
+		return 0, err
 	}
 
-	return fmt.Fprintf(w.writer, "%x\r\n%s\r\n", len(p), p)
+	if w.encoder != nil {
+		return w.encoder.Write(p)
+	}
+	return w.writeChunk(p)
+}
+
+func (w *Writer) writeChunk(p []byte) (int, error) {
+	return w.writeChunkExt(p, "")
 }
 
+// writeChunkExt sends p as one chunk with the formatted extensions ext. An
+// empty p sends nothing, since a zero-length chunk would end the body.
+func (w *Writer) writeChunkExt(p []byte, ext string) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if _, err := fmt.Fprintf(w.writer, "%x%s\r\n", len(p), ext); err != nil {
+		return 0, err
+	}
+	n, err := w.writer.Write(p)
+	if err != nil {
+		return n, err
+	}
+	if _, err := w.writer.Write([]byte("\r\n")); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+// WriteChunkedBodyDone ends a chunked body, with the trailers set so far. It
+// returns the number of bytes of the last chunk and trailers.
 func (w *Writer) WriteChunkedBodyDone() (int, error) {
-	if w.state != writerStateWriteBody {
-		return 0, fmt.Errorf("cannot chucked body done in state %d", w.state)
+	if err := w.checkChunked("end chunked body"); err != nil {
+		return 0, err
 	}
 
-	defer func() { w.state = writerStateDone }()
+	defer func() { w.state = StateDone }()
 
-	return w.writer.Write([]byte("0\r\n\r\n"))
+	if err := w.closeEncoder(); err != nil {
+		return 0, err
+	}
+	n, err := w.writeLastChunk()
+	if err != nil {
+		return n, err
+	}
+	return n, w.Flush()
 }
 
+// WriteTrailers ends a chunked body with the given trailer fields, along with
+// any set with SetTrailer. Every field must have been declared in the Trailer
+// header, and none may be one of the fields prohibited in trailers.
 func (w *Writer) WriteTrailers(trailers headers.Headers) error {
-	if w.state != writerStateWriteBody {
-		return fmt.Errorf("cannot write trailers in state %d", w.state)
+	if err := w.checkChunked("write trailers"); err != nil {
+		return err
 	}
-	defer func() { w.state = writerStateDone }()
-
-	_, err := w.writer.Write([]byte("0\r\n"))
-	if err != nil {
+	if err := trailers.Validate(); err != nil {
 		return err
 	}
-	for key, value := range trailers {
-		_, err := fmt.Fprintf(w.writer, "%s: %s\r\n", key, value)
-		if err != nil {
+	for key := range trailers {
+		if err := w.checkTrailer(key); err != nil {
 			return err
 		}
 	}
-	_, err = fmt.Fprint(w.writer, "\r\n")
-	return err
+	defer func() { w.state = StateDone }()
+
+	if w.trailers == nil {
+		w.trailers = headers.NewHeaders()
+	}
+	for key, value := range trailers {
+		w.trailers.Override(key, value)
+	}
+	if err := w.closeEncoder(); err != nil {
+		return err
+	}
+	if _, err := w.writeLastChunk(); err != nil {
+		return err
+	}
+	return w.Flush()
 }
@@ -0,0 +1,473 @@
+package response
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xixotron/httpfromtcp/internal/headers"
+)
+
+// tcpPair returns both ends of a loopback TCP connection.
+func tcpPair(tb testing.TB) (server net.Conn, client net.Conn) {
+	tb.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(tb, err)
+	defer listener.Close()
+
+	accepted := make(chan net.Conn)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		accepted <- conn
+	}()
+	client, err = net.Dial("tcp", listener.Addr().String())
+	require.NoError(tb, err)
+	server = <-accepted
+	require.NotNil(tb, server)
+	return server, client
+}
+
+func writeTempFile(tb testing.TB, size int) *os.File {
+	tb.Helper()
+	name := filepath.Join(tb.TempDir(), "body.bin")
+	require.NoError(tb, os.WriteFile(name, bytes.Repeat([]byte("0123456789abcdef"), size/16), 0o644))
+	file, err := os.Open(name)
+	require.NoError(tb, err)
+	tb.Cleanup(func() { file.Close() })
+	return file
+}
+
+func TestWriterReadFrom(t *testing.T) {
+	file := writeTempFile(t, 1<<16)
+
+	// Test: File over TCP
+	server, client := tcpPair(t)
+	defer client.Close()
+	received := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(client)
+		received <- data
+	}()
+
+	w := NewWriter(server)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(1<<15)))
+	n, err := w.ReadFrom(io.LimitReader(file, 1<<15))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1<<15), n)
+	server.Close()
+
+	data := <-received
+	assert.True(t, bytes.HasSuffix(data, bytes.Repeat([]byte("0123456789abcdef"), 1<<11)))
+
+	// Test: Chunked body from a reader
+	buff := &bytes.Buffer{}
+	w = NewWriter(buff)
+	h := GetDefaultHeaders(0)
+	h.Remove("Content-Length")
+	h.Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(h))
+	n, err = w.ReadFrom(bytes.NewReader([]byte("hello")))
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), n)
+	assert.True(t, bytes.HasSuffix(buff.Bytes(), []byte("\r\n\r\n5\r\nhello\r\n0\r\n\r\n")))
+
+	// Test: Body after completion
+	_, err = w.ReadFrom(bytes.NewReader([]byte("again")))
+	require.Error(t, err)
+}
+
+// countingWriter counts the Write calls reaching it, each of which would be a
+// write syscall on a real connection.
+type countingWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	cw.writes++
+	return cw.Buffer.Write(p)
+}
+
+func TestWriterBuffering(t *testing.T) {
+	// Test: Small response in a single write
+	conn := &countingWriter{}
+	w := NewWriter(conn)
+	body := []byte("Your request was an absolute banger.")
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(len(body))))
+	assert.Equal(t, 0, conn.writes)
+	_, err := w.WriteBody(body)
+	require.NoError(t, err)
+	assert.Equal(t, 1, conn.writes)
+	assert.True(t, bytes.HasSuffix(conn.Bytes(), body))
+
+	// Test: Chunks stay buffered until Flush
+	conn = &countingWriter{}
+	w = NewWriter(conn)
+	h := GetDefaultHeaders(0)
+	h.Remove("Content-Length")
+	h.Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(h))
+	_, err = w.WriteChunkedBody([]byte("event one"))
+	require.NoError(t, err)
+	assert.Equal(t, 0, conn.writes)
+	require.NoError(t, w.Flush())
+	assert.Equal(t, 1, conn.writes)
+	assert.True(t, bytes.HasSuffix(conn.Bytes(), []byte("9\r\nevent one\r\n")))
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	assert.Equal(t, 2, conn.writes)
+	assert.True(t, bytes.HasSuffix(conn.Bytes(), []byte("0\r\n\r\n")))
+
+	// Test: Small buffer writes through when full
+	conn = &countingWriter{}
+	w = NewWriterSize(conn, 16)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(len(body))))
+	assert.Greater(t, conn.writes, 0)
+}
+
+func TestWriterKeepAlive(t *testing.T) {
+	respond := func(h headers.Headers, body string) *Writer {
+		w := NewWriter(io.Discard)
+		w.WriteStatusLine(StatusOK)
+		w.WriteHeaders(h)
+		if body != "" {
+			w.WriteBody([]byte(body))
+		}
+		return w
+	}
+	lengthHeaders := func(n int) headers.Headers {
+		h := headers.NewHeaders()
+		h.Set("Content-Length", strconv.Itoa(n))
+		return h
+	}
+
+	// Test: Complete response with Content-Length
+	assert.True(t, respond(lengthHeaders(5), "hello").KeepAlive())
+
+	// Test: Connection: close
+	assert.False(t, respond(GetDefaultHeaders(5), "hello").KeepAlive())
+
+	// Test: Body delimited only by closing the connection
+	assert.False(t, respond(headers.NewHeaders(), "hello").KeepAlive())
+
+	// Test: Unfinished body
+	assert.False(t, respond(lengthHeaders(5), "").KeepAlive())
+
+	// Test: HEAD response ends with its headers
+	w := NewWriter(io.Discard)
+	w.SetRequestMethod("HEAD")
+	w.WriteStatusLine(StatusOK)
+	w.WriteHeaders(lengthHeaders(5))
+	assert.True(t, w.KeepAlive())
+
+	// Test: Hijacked connections are never reused
+	server, client := tcpPair(t)
+	defer server.Close()
+	defer client.Close()
+	w = NewWriter(server)
+	_, _, err := w.Hijack()
+	require.NoError(t, err)
+	assert.False(t, w.KeepAlive())
+}
+
+func BenchmarkWriterFileBody(b *testing.B) {
+	const size = 8 << 20
+
+	b.Run("ReadFrom", func(b *testing.B) {
+		file := writeTempFile(b, size)
+		b.SetBytes(size)
+		for b.Loop() {
+			server, client := tcpPair(b)
+			go io.Copy(io.Discard, client)
+			_, err := file.Seek(0, io.SeekStart)
+			require.NoError(b, err)
+
+			w := NewWriter(server)
+			w.WriteStatusLine(StatusOK)
+			w.WriteHeaders(GetDefaultHeaders(size))
+			_, err = w.ReadFrom(file)
+			require.NoError(b, err)
+			server.Close()
+			client.Close()
+		}
+	})
+
+	// the way handleVideo used to stream the file, for comparison
+	b.Run("ChunkedCopy", func(b *testing.B) {
+		file := writeTempFile(b, size)
+		b.SetBytes(size)
+		for b.Loop() {
+			server, client := tcpPair(b)
+			go io.Copy(io.Discard, client)
+			_, err := file.Seek(0, io.SeekStart)
+			require.NoError(b, err)
+
+			w := NewWriter(server)
+			h := GetDefaultHeaders(0)
+			h.Remove("Content-Length")
+			h.Set("Transfer-Encoding", "chunked")
+			w.WriteStatusLine(StatusOK)
+			w.WriteHeaders(h)
+			buff := make([]byte, 1024)
+			for {
+				n, err := file.Read(buff)
+				if n > 0 {
+					_, werr := w.WriteChunkedBody(buff[:n])
+					require.NoError(b, werr)
+				}
+				if err == io.EOF {
+					break
+				}
+				require.NoError(b, err)
+			}
+			_, err = w.WriteChunkedBodyDone()
+			require.NoError(b, err)
+			server.Close()
+			client.Close()
+		}
+	})
+}
+
+func TestWriterTrailers(t *testing.T) {
+	chunkedHeaders := func(trailer string) headers.Headers {
+		h := headers.NewHeaders()
+		h.Set("Transfer-Encoding", "chunked")
+		h.Set("Trailer", trailer)
+		return h
+	}
+
+	// Test: Trailers set while streaming are sent after the last chunk
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(chunkedHeaders("X-Sum, X-Count")))
+	_, err := w.WriteChunkedBody([]byte("abc"))
+	require.NoError(t, err)
+	require.NoError(t, w.SetTrailer("X-Sum", "1"))
+	require.NoError(t, w.SetTrailer("X-Sum", "2"))
+	n, err := w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	assert.Equal(t, len("0\r\nx-sum: 2\r\n\r\n"), n)
+	assert.True(t, bytes.HasSuffix(buf.Bytes(), []byte("3\r\nabc\r\n0\r\nx-sum: 2\r\n\r\n")))
+
+	// Test: WriteTrailers adds to the trailers already set
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(chunkedHeaders("X-Sum, X-Count")))
+	require.NoError(t, w.SetTrailer("X-Sum", "1"))
+	trailers := headers.NewHeaders()
+	trailers.Set("X-Count", "3")
+	require.NoError(t, w.WriteTrailers(trailers))
+	assert.Contains(t, buf.String(), "\r\n0\r\n")
+	assert.Contains(t, buf.String(), "x-sum: 1\r\n")
+	assert.Contains(t, buf.String(), "x-count: 3\r\n")
+
+	// Test: Undeclared trailers are refused
+	w = NewWriter(io.Discard)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(chunkedHeaders("X-Sum")))
+	require.ErrorIs(t, w.SetTrailer("X-Other", "1"), ErrTrailerNotDeclared)
+	trailers = headers.NewHeaders()
+	trailers.Set("X-Other", "1")
+	require.ErrorIs(t, w.WriteTrailers(trailers), ErrTrailerNotDeclared)
+
+	// Test: Trailer values cannot inject extra lines
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(chunkedHeaders("X-Sum")))
+	require.Error(t, w.SetTrailer("X-Sum", "1\r\nX-Evil: 1"))
+	require.Error(t, w.SetTrailer("X Sum", "1"))
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	assert.True(t, bytes.HasSuffix(buf.Bytes(), []byte("\r\n\r\n0\r\n\r\n")))
+
+	// Test: Prohibited fields can neither be declared nor sent
+	w = NewWriter(io.Discard)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.ErrorIs(t, w.WriteHeaders(chunkedHeaders("X-Sum, Content-Length")), ErrTrailerProhibited)
+	w = NewWriter(io.Discard)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(chunkedHeaders("X-Sum")))
+	trailers = headers.NewHeaders()
+	trailers.Set("Host", "example.com")
+	require.ErrorIs(t, w.WriteTrailers(trailers), ErrTrailerProhibited)
+	assert.True(t, TrailerAllowed("X-Content-SHA256"))
+	assert.False(t, TrailerAllowed("Transfer-Encoding"))
+
+	// Test: Trailers need a chunked body
+	w = NewWriter(io.Discard)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	h := GetDefaultHeaders(0)
+	h.Set("Trailer", "X-Sum")
+	require.NoError(t, w.WriteHeaders(h))
+	require.ErrorIs(t, w.SetTrailer("X-Sum", "1"), ErrChunkedNotEnabled)
+}
+
+func TestWriterStatusLine(t *testing.T) {
+	statusLine := func(write func(w *Writer)) string {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		write(w)
+		require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+		require.NoError(t, w.Flush())
+		line, _, _ := bytes.Cut(buf.Bytes(), []byte("\r\n"))
+		return string(line)
+	}
+
+	// Test: Usual reason phrases, also for codes without a constant
+	assert.Equal(t, "HTTP/1.1 404 Not Found", statusLine(func(w *Writer) { w.WriteStatusLine(StatusNotFound) }))
+	assert.Equal(t, "HTTP/1.1 429 Too Many Requests", statusLine(func(w *Writer) { w.WriteStatusLine(429) }))
+	assert.Equal(t, "HTTP/1.1 299 Unknown Status", statusLine(func(w *Writer) { w.WriteStatusLine(299) }))
+
+	// Test: A reason phrase of the caller's choosing
+	assert.Equal(t, "HTTP/1.1 200 Fine, thanks", statusLine(func(w *Writer) {
+		require.NoError(t, w.WriteStatusLineReason(StatusOK, "Fine, thanks"))
+	}))
+	assert.Equal(t, "HTTP/1.1 200 OK", statusLine(func(w *Writer) {
+		require.NoError(t, w.WriteStatusLineReason(StatusOK, ""))
+	}))
+
+	// Test: Reason phrases cannot inject lines
+	w := NewWriter(io.Discard)
+	require.Error(t, w.WriteStatusLineReason(StatusOK, "OK\r\nX-Evil: 1"))
+	assert.Equal(t, StateStatusLine, w.State())
+}
+
+func TestWriterState(t *testing.T) {
+	// Test: State follows the response
+	w := NewWriter(io.Discard)
+	assert.Equal(t, StateStatusLine, w.State())
+	_, err := w.WriteBody(nil)
+	require.ErrorIs(t, err, ErrHeadersNotWritten)
+	require.ErrorIs(t, w.WriteHeaders(headers.NewHeaders()), ErrStatusNotWritten)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	assert.Equal(t, StateHeaders, w.State())
+	require.ErrorIs(t, w.WriteStatusLine(StatusOK), ErrStatusAlreadyWritten)
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(2)))
+	assert.Equal(t, StateBody, w.State())
+	require.ErrorIs(t, w.WriteHeaders(GetDefaultHeaders(2)), ErrHeadersAlreadyWritten)
+	_, err = w.WriteBody([]byte("ok"))
+	require.NoError(t, err)
+	assert.Equal(t, StateDone, w.State())
+	assert.Equal(t, "done", w.State().String())
+
+	// Test: Writes after the response is complete
+	_, err = w.WriteBody([]byte("more"))
+	require.ErrorIs(t, err, ErrBodyAfterDone)
+	_, err = w.ReadFrom(bytes.NewReader(nil))
+	require.ErrorIs(t, err, ErrBodyAfterDone)
+	_, err = w.WriteChunkedBodyDone()
+	require.ErrorIs(t, err, ErrBodyAfterDone)
+
+	// Test: Chunks are refused without chunked Transfer-Encoding
+	var buf bytes.Buffer
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(5)))
+	_, err = w.WriteChunkedBody([]byte("hello"))
+	require.ErrorIs(t, err, ErrChunkedNotEnabled)
+	_, err = w.WriteChunkedBodyDone()
+	require.ErrorIs(t, err, ErrChunkedNotEnabled)
+	require.ErrorIs(t, w.WriteTrailers(headers.NewHeaders()), ErrChunkedNotEnabled)
+	assert.Equal(t, StateBody, w.State())
+	_, err = w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+	assert.True(t, bytes.HasSuffix(buf.Bytes(), []byte("\r\n\r\nhello")))
+
+	// Test: Nothing works after Hijack
+	server, client := tcpPair(t)
+	defer server.Close()
+	defer client.Close()
+	w = NewWriter(server)
+	_, _, err = w.Hijack()
+	require.NoError(t, err)
+	assert.Equal(t, StateHijacked, w.State())
+	require.ErrorIs(t, w.WriteStatusLine(StatusOK), ErrHijacked)
+	_, _, err = w.Hijack()
+	require.ErrorIs(t, err, ErrHijacked)
+}
+
+func TestWriterContentLength(t *testing.T) {
+	// Test: Body longer than Content-Length is refused
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(3)))
+	_, err := w.WriteBody([]byte("toolong"))
+	require.ErrorIs(t, err, ErrContentLengthExceeded)
+	require.NoError(t, w.Flush())
+	assert.True(t, bytes.HasSuffix(buf.Bytes(), []byte("\r\n\r\n")))
+	assert.False(t, w.KeepAlive())
+
+	// Test: ReadFrom stops at Content-Length and reports the rest
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(3)))
+	n, err := w.ReadFrom(bytes.NewReader([]byte("toolong")))
+	require.ErrorIs(t, err, ErrContentLengthExceeded)
+	assert.Equal(t, int64(3), n)
+	assert.False(t, w.KeepAlive())
+
+	// Test: Short body completes the response but not the connection
+	keepAlive := func() headers.Headers {
+		h := GetDefaultHeaders(10)
+		h.Override("Connection", "keep-alive")
+		return h
+	}
+	w = NewWriter(io.Discard)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(keepAlive()))
+	_, err = w.WriteBody([]byte("short"))
+	require.NoError(t, err)
+	assert.Equal(t, StateDone, w.State())
+	assert.False(t, w.KeepAlive())
+	w = NewWriter(io.Discard)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(keepAlive()))
+	_, err = w.ReadFrom(bytes.NewReader([]byte("short")))
+	require.NoError(t, err)
+	assert.False(t, w.KeepAlive())
+
+	// Test: Exact body keeps the connection
+	w = NewWriter(io.Discard)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(keepAlive()))
+	n, err = w.ReadFrom(bytes.NewReader([]byte("0123456789")))
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), n)
+	assert.True(t, w.KeepAlive())
+
+	// Test: Content-Length does not apply to 304 responses
+	w = NewWriter(io.Discard)
+	require.NoError(t, w.WriteStatusLine(StatusNotModified))
+	require.NoError(t, w.WriteHeaders(keepAlive()))
+	_, err = w.WriteBody(nil)
+	require.NoError(t, err)
+	assert.True(t, w.KeepAlive())
+
+	// Test: Invalid Content-Length
+	w = NewWriter(io.Discard)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	h := keepAlive()
+	h.Override("Content-Length", "ten")
+	require.Error(t, w.WriteHeaders(h))
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/xixotron/httpfromtcp/internal/request"
+	"github.com/xixotron/httpfromtcp/internal/response"
+)
+
+// Compress wraps next so that compressible response bodies are sent with the
+// gzip or deflate content coding when the client's Accept-Encoding allows it.
+func Compress(next Handler) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		w.EnableCompression(req.Headers.Get("Accept-Encoding"))
+		next(w, req)
+	}
+}
+
+// Conditional wraps next so that successful responses to GET and HEAD
+// carrying an ETag or Last-Modified header are checked against the request's
+// If-Match, If-None-Match, If-Modified-Since and If-Unmodified-Since headers,
+// and replaced with 304 Not Modified or 412 Precondition Failed when they
+// fail. Other methods pass through untouched: their handlers must call
+// response.CheckPreconditions before changing anything.
+func Conditional(next Handler) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		w.EnablePreconditions(req.RequestLine.Method, req.Headers)
+		next(w, req)
+	}
+}
+
+// DecompressBody wraps next so that request bodies sent with a gzip or deflate
+// Content-Encoding reach it already decoded. Bodies that decode to more than
+// maxSize bytes are refused with 413, unknown codings with 415.
+func DecompressBody(maxSize int, next Handler) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		err := req.DecodeBody(maxSize)
+		switch {
+		case err == nil:
+			next(w, req)
+		case errors.Is(err, request.ErrUnsupportedContentEncoding):
+			message := fmt.Sprintf("Unsupported request body: %v", err)
+			h := response.GetDefaultHeaders(len(message))
+			h.Set("Accept-Encoding", "gzip, deflate")
+			w.WriteStatusLine(response.StatusUnsupportedMedia)
+			w.WriteHeaders(h)
+			w.WriteBody([]byte(message))
+		case errors.Is(err, request.ErrBodyTooLarge):
+			writeError(w, response.StatusContentTooLarge, "Request body too large")
+		default:
+			log.Printf("Error decoding request body: %v", err)
+			writeError(w, response.StatusBadRequest, fmt.Sprintf("Error decoding request body: %v", err))
+		}
+	}
+}
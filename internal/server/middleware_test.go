@@ -0,0 +1,74 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xixotron/httpfromtcp/internal/headers"
+	"github.com/xixotron/httpfromtcp/internal/request"
+	"github.com/xixotron/httpfromtcp/internal/response"
+)
+
+func TestConditional(t *testing.T) {
+	var updates atomic.Int32
+	conn := startServer(t, Conditional(func(w *response.Writer, req *request.Request) {
+		etag := `"v1"`
+		if req.RequestLine.Method == "PUT" {
+			if status := response.CheckPreconditions("PUT", req.Headers, etag, time.Time{}); status != response.StatusOK {
+				w.WriteStatusLine(status)
+				w.WriteHeaders(headers.Headers{"content-length": "0"})
+				w.WriteBody(nil)
+				return
+			}
+			updates.Add(1)
+			etag = `"v2"`
+		}
+		h := headers.NewHeaders()
+		h.Set("Content-Length", "5")
+		h.Set("ETag", etag)
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(h)
+		w.WriteBody([]byte("hello"))
+	}))
+	reader := bufio.NewReader(conn)
+	status := func() string {
+		t.Helper()
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		for {
+			header, err := reader.ReadString('\n')
+			require.NoError(t, err)
+			if header == "\r\n" {
+				break
+			}
+		}
+		return line
+	}
+
+	// Test: A GET with a matching If-None-Match is answered with 304
+	_, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\nIf-None-Match: \"v1\"\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 304 Not Modified\r\n", status())
+
+	// Test: A PUT with a failing If-Match is refused before any change is made
+	_, err = io.WriteString(conn, "PUT / HTTP/1.1\r\nHost: localhost\r\nIf-Match: \"v0\"\r\nContent-Length: 0\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 412 Precondition Failed\r\n", status())
+	assert.Zero(t, updates.Load())
+
+	// Test: The response to a PUT is not checked again against its new ETag
+	_, err = io.WriteString(conn, "PUT / HTTP/1.1\r\nHost: localhost\r\nIf-Match: \"v1\"\r\nContent-Length: 0\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", status())
+	body := make([]byte, 5)
+	_, err = io.ReadFull(reader, body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+	assert.Equal(t, int32(1), updates.Load())
+}
@@ -1,10 +1,13 @@
 package server
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"sync/atomic"
+	"time"
 
 	"github.com/xixotron/httpfromtcp/internal/request"
 	"github.com/xixotron/httpfromtcp/internal/response"
@@ -41,6 +44,12 @@ func (s *Server) Close() error {
 	return nil
 }
 
+// Addr returns the address the server listens on, useful after asking for
+// port 0.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
 func (s *Server) accept() {
 	for {
 		conn, err := s.listener.Accept()
@@ -55,18 +64,66 @@ func (s *Server) accept() {
 	}
 }
 
+// idleTimeout is how long a kept-alive connection may wait for its next
+// request.
+const idleTimeout = 2 * time.Minute
+
 func (s *Server) handle(conn net.Conn) {
-	defer conn.Close()
-	w := response.NewWriter(conn)
-	req, err := request.RequestFromReader(conn)
-	if err != nil {
-		w.WriteStatusLine(response.StatusBadRequest)
-		body := fmt.Appendf(nil, "Error parsing request: %v", err.Error())
-		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
-		w.WriteBody(body)
+	hijacked := false
+	defer func() {
+		// a hijacked connection belongs to the handler now
+		if !hijacked {
+			conn.Close()
+		}
+	}()
+
+	reader := request.NewReader(conn, request.Options{})
+	for {
+		conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		w := response.NewWriter(conn)
+		w.AttachReader(reader)
+		req, err := reader.ReadRequest()
+		if err != nil {
+			var netErr net.Error
+			if errors.Is(err, io.EOF) || (errors.As(err, &netErr) && netErr.Timeout()) {
+				// the client closed or abandoned an idle connection
+				return
+			}
+			// the framing of whatever follows is unknown, so the connection is
+			// always closed after reporting the error
+			status := response.StatusBadRequest
+			if errors.Is(err, request.ErrUnsupportedTransferCoding) {
+				status = response.StatusNotImplemented
+			}
+			writeError(w, status, fmt.Sprintf("Error parsing request: %v", err.Error()))
 
-		log.Printf("Error parsing request: %v", err)
-		return
+			log.Printf("Error parsing request: %v", err)
+			return
+		}
+		// handlers may stream or hijack for as long as they like
+		conn.SetReadDeadline(time.Time{})
+		req.RemoteAddr = conn.RemoteAddr().String()
+		w.SetRequestMethod(req.RequestLine.Method)
+
+		s.handler(w, req)
+		if w.Hijacked() {
+			hijacked = true
+			return
+		}
+		// the handler may have stopped without completing its response, such as
+		// after the headers of a HEAD request
+		if err := w.Flush(); err != nil {
+			log.Printf("Error writing response: %v", err)
+			return
+		}
+		if req.Headers.HasToken("Connection", "close") || !w.KeepAlive() {
+			return
+		}
 	}
-	s.handler(w, req)
+}
+
+func writeError(w *response.Writer, statusCode response.StatusCode, message string) {
+	w.WriteStatusLine(statusCode)
+	w.WriteHeaders(response.GetDefaultHeaders(len(message)))
+	w.WriteBody([]byte(message))
 }
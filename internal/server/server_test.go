@@ -0,0 +1,168 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xixotron/httpfromtcp/internal/headers"
+	"github.com/xixotron/httpfromtcp/internal/request"
+	"github.com/xixotron/httpfromtcp/internal/response"
+)
+
+func startServer(t *testing.T, handler Handler) net.Conn {
+	t.Helper()
+	s, err := Serve(0, handler)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// echoTarget answers with the request target, leaving the connection open.
+func echoTarget(w *response.Writer, req *request.Request) {
+	body := req.RequestLine.RequestTarget
+	h := headers.NewHeaders()
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(h)
+	w.WriteBody([]byte(body))
+}
+
+// readResponseBody reads one response with a Content-Length body and returns
+// the body.
+func readResponseBody(t *testing.T, reader *bufio.Reader) string {
+	t.Helper()
+	statusLine, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "HTTP/1.1 200 OK\r\n", statusLine)
+	length := 0
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\r\n" {
+			break
+		}
+		if value, ok := strings.CutPrefix(line, "content-length: "); ok {
+			length, err = strconv.Atoi(value[:len(value)-2])
+			require.NoError(t, err)
+		}
+	}
+	body := make([]byte, length)
+	_, err = io.ReadFull(reader, body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestServerKeepAlive(t *testing.T) {
+	conn := startServer(t, echoTarget)
+	reader := bufio.NewReader(conn)
+
+	// Test: Pipelined requests are answered in order on one connection
+	_, err := io.WriteString(conn, "GET /one HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+		"POST /two HTTP/1.1\r\nHost: localhost\r\nContent-Length: 3\r\n\r\nabc")
+	require.NoError(t, err)
+	assert.Equal(t, "/one", readResponseBody(t, reader))
+	assert.Equal(t, "/two", readResponseBody(t, reader))
+
+	// Test: Connection: close ends the connection after the response
+	_, err = io.WriteString(conn, "GET /three HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "/three", readResponseBody(t, reader))
+	rest, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Empty(t, rest)
+}
+
+func TestServerHead(t *testing.T) {
+	conn := startServer(t, echoTarget)
+	reader := bufio.NewReader(conn)
+
+	// Test: The body written for HEAD is not sent and the connection stays open
+	_, err := io.WriteString(conn, "HEAD /head HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+		"GET /get HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	var head strings.Builder
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		head.WriteString(line)
+		if line == "\r\n" {
+			break
+		}
+	}
+	assert.Equal(t, "HTTP/1.1 200 OK\r\ncontent-length: 5\r\n\r\n", head.String())
+	assert.Equal(t, "/get", readResponseBody(t, reader))
+}
+
+func TestServerClosesUndelimitedResponse(t *testing.T) {
+	// Test: A body without Content-Length can only end with the connection
+	conn := startServer(t, func(w *response.Writer, _ *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(headers.NewHeaders())
+		w.WriteBody([]byte("until close"))
+	})
+	_, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	data, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n\r\nuntil close", string(data))
+}
+
+func TestServerClosesShortBody(t *testing.T) {
+	// Test: A body shorter than its Content-Length ends the connection
+	conn := startServer(t, func(w *response.Writer, _ *request.Request) {
+		h := headers.NewHeaders()
+		h.Set("Content-Length", "100")
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(h)
+		w.WriteBody([]byte("only some"))
+	})
+	_, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	data, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\ncontent-length: 100\r\n\r\nonly some", string(data))
+}
+
+func TestServerHijack(t *testing.T) {
+	// Test: Bytes read past the request are handed over with the connection
+	conn := startServer(t, func(w *response.Writer, _ *request.Request) {
+		hijacked, buffered, err := w.Hijack()
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer hijacked.Close()
+		// from here on the server neither reads from nor closes the connection
+		reader := bufio.NewReader(io.MultiReader(bytes.NewReader(buffered), hijacked))
+		for range 2 {
+			line, err := reader.ReadString('\n')
+			if !assert.NoError(t, err) {
+				return
+			}
+			io.WriteString(hijacked, "echo:"+line)
+		}
+	})
+	_, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\nearly bytes\n")
+	require.NoError(t, err)
+
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "echo:early bytes\n", line)
+
+	_, err = io.WriteString(conn, "later bytes\n")
+	require.NoError(t, err)
+	line, err = reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "echo:later bytes\n", line)
+}
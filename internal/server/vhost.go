@@ -0,0 +1,69 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/xixotron/httpfromtcp/internal/request"
+	"github.com/xixotron/httpfromtcp/internal/response"
+)
+
+// HostRouter dispatches requests to a different Handler depending on the
+// request's Host, so one Server can serve several sites.
+//
+// Patterns are either an exact host name such as "example.com", or a wildcard
+// such as "*.example.com" which matches any subdomain of example.com but not
+// example.com itself. Exact matches win over wildcards, and longer wildcards
+// win over shorter ones.
+type HostRouter struct {
+	hosts     map[string]Handler
+	wildcards map[string]Handler
+	fallback  Handler
+}
+
+// NewHostRouter returns a HostRouter that sends requests for unknown hosts to
+// fallback. With a nil fallback those requests get 421 Misdirected Request.
+func NewHostRouter(fallback Handler) *HostRouter {
+	return &HostRouter{
+		hosts:     map[string]Handler{},
+		wildcards: map[string]Handler{},
+		fallback:  fallback,
+	}
+}
+
+// Handle registers handler for the hosts matching pattern.
+func (hr *HostRouter) Handle(pattern string, handler Handler) {
+	pattern = strings.TrimSuffix(strings.ToLower(pattern), ".")
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		hr.wildcards[suffix] = handler
+		return
+	}
+	hr.hosts[pattern] = handler
+}
+
+// Serve is a Handler that forwards req to the handler registered for its host.
+func (hr *HostRouter) Serve(w *response.Writer, req *request.Request) {
+	handler := hr.match(req.Host)
+	if handler == nil {
+		writeError(w, response.StatusMisdirectedRequest, "No site configured for this host")
+		return
+	}
+	handler(w, req)
+}
+
+func (hr *HostRouter) match(host string) Handler {
+	if handler, ok := hr.hosts[host]; ok {
+		return handler
+	}
+	// walk up the labels so the longest matching wildcard is found first
+	for i := strings.IndexByte(host, '.'); i != -1; {
+		if handler, ok := hr.wildcards[host[i+1:]]; ok {
+			return handler
+		}
+		next := strings.IndexByte(host[i+1:], '.')
+		if next == -1 {
+			break
+		}
+		i += next + 1
+	}
+	return hr.fallback
+}
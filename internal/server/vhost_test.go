@@ -0,0 +1,52 @@
+package server
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xixotron/httpfromtcp/internal/request"
+	"github.com/xixotron/httpfromtcp/internal/response"
+)
+
+func TestHostRouter(t *testing.T) {
+	var matched string
+	handlerFor := func(name string) Handler {
+		return func(_ *response.Writer, _ *request.Request) { matched = name }
+	}
+
+	router := NewHostRouter(nil)
+	router.Handle("Example.com", handlerFor("exact"))
+	router.Handle("*.example.com", handlerFor("wildcard"))
+	router.Handle("*.api.example.com", handlerFor("api"))
+
+	route := func(host string) string {
+		matched = ""
+		buff := &bytes.Buffer{}
+		router.Serve(response.NewWriter(buff), &request.Request{Host: host})
+		if matched == "" {
+			return buff.String()
+		}
+		return matched
+	}
+
+	// Test: Exact host
+	assert.Equal(t, "exact", route("example.com"))
+
+	// Test: Wildcard subdomain
+	assert.Equal(t, "wildcard", route("www.example.com"))
+	assert.Equal(t, "wildcard", route("a.b.example.com"))
+
+	// Test: Longest wildcard wins
+	assert.Equal(t, "api", route("v1.api.example.com"))
+	assert.Equal(t, "wildcard", route("api.example.com"))
+
+	// Test: Unknown host without fallback
+	assert.Contains(t, route("example.org"), "HTTP/1.1 421 Misdirected Request\r\n")
+	assert.Contains(t, route("notexample.com"), "421")
+
+	// Test: Unknown host with fallback
+	router = NewHostRouter(handlerFor("fallback"))
+	assert.Equal(t, "fallback", route("example.org"))
+}
@@ -0,0 +1,162 @@
+package sse
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xixotron/httpfromtcp/internal/headers"
+	"github.com/xixotron/httpfromtcp/internal/request"
+	"github.com/xixotron/httpfromtcp/internal/response"
+)
+
+// ErrClosed is returned when sending on a Stream that was closed, or whose
+// client went away.
+var ErrClosed = errors.New("event stream closed")
+
+// Event is a single Server-Sent Event. Empty fields are left out.
+type Event struct {
+	ID    string
+	Event string
+	Data  string
+	// Retry tells the browser how long to wait before reconnecting.
+	Retry time.Duration
+}
+
+// Stream sends Server-Sent Events (text/event-stream) over a chunked
+// response. Every event is flushed as soon as it is written, and a comment
+// line is sent every heartbeat interval so idle proxies keep the connection
+// open and a disconnected client is noticed.
+type Stream struct {
+	w           *response.Writer
+	lastEventID string
+
+	mu     sync.Mutex
+	err    error
+	done   chan struct{}
+	ticker *time.Ticker
+}
+
+// NewStream writes the response headers for an event stream and starts the
+// heartbeat. A heartbeat of 0 disables it.
+func NewStream(w *response.Writer, req *request.Request, heartbeat time.Duration) (*Stream, error) {
+	h := headers.NewHeaders()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Transfer-Encoding", "chunked")
+	h.Set("Connection", "close")
+
+	if err := w.WriteStatusLine(response.StatusOK); err != nil {
+		return nil, err
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return nil, err
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+
+	s := &Stream{
+		w:           w,
+		lastEventID: req.Headers.Get("Last-Event-ID"),
+		done:        make(chan struct{}),
+	}
+	if heartbeat > 0 {
+		s.ticker = time.NewTicker(heartbeat)
+		go s.heartbeat()
+	}
+	return s, nil
+}
+
+// LastEventID is the ID of the last event the client saw before reconnecting,
+// taken from the Last-Event-ID request header. It is empty on a first
+// connection.
+func (s *Stream) LastEventID() string {
+	return s.lastEventID
+}
+
+// Done is closed once the stream ends, either because Close was called or
+// because writing to the client failed.
+func (s *Stream) Done() <-chan struct{} {
+	return s.done
+}
+
+// Send writes ev and flushes it to the client.
+func (s *Stream) Send(ev Event) error {
+	if strings.ContainsAny(ev.ID, "\r\n\x00") || strings.ContainsAny(ev.Event, "\r\n") {
+		return fmt.Errorf("event id and type must be a single line")
+	}
+
+	var sb strings.Builder
+	if ev.Event != "" {
+		fmt.Fprintf(&sb, "event: %s\n", ev.Event)
+	}
+	if ev.ID != "" {
+		fmt.Fprintf(&sb, "id: %s\n", ev.ID)
+	}
+	if ev.Retry > 0 {
+		fmt.Fprintf(&sb, "retry: %d\n", ev.Retry.Milliseconds())
+	}
+	data := strings.ReplaceAll(ev.Data, "\r\n", "\n")
+	data = strings.ReplaceAll(data, "\r", "\n")
+	for line := range strings.SplitSeq(data, "\n") {
+		fmt.Fprintf(&sb, "data: %s\n", line)
+	}
+	sb.WriteString("\n")
+
+	return s.write(sb.String())
+}
+
+// Close stops the heartbeat and ends the response.
+func (s *Stream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil
+	}
+	s.stop(ErrClosed)
+	_, err := s.w.WriteChunkedBodyDone()
+	return err
+}
+
+func (s *Stream) heartbeat() {
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.ticker.C:
+			s.write(": heartbeat\n\n")
+		}
+	}
+}
+
+func (s *Stream) write(data string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	if _, err := s.w.WriteChunkedBody([]byte(data)); err != nil {
+		s.stop(err)
+		return s.err
+	}
+	if err := s.w.Flush(); err != nil {
+		s.stop(err)
+		return s.err
+	}
+	return nil
+}
+
+// stop must be called with mu held.
+func (s *Stream) stop(err error) {
+	if !errors.Is(err, ErrClosed) {
+		err = fmt.Errorf("%w: %w", ErrClosed, err)
+	}
+	s.err = err
+	if s.ticker != nil {
+		s.ticker.Stop()
+	}
+	close(s.done)
+}
@@ -0,0 +1,105 @@
+package sse
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xixotron/httpfromtcp/internal/request"
+	"github.com/xixotron/httpfromtcp/internal/response"
+)
+
+// safeBuffer lets the heartbeat goroutine and the test share a buffer, and
+// can start failing writes to simulate a client that went away.
+type safeBuffer struct {
+	mu     sync.Mutex
+	buff   bytes.Buffer
+	broken bool
+}
+
+func (sb *safeBuffer) Write(p []byte) (int, error) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	if sb.broken {
+		return 0, errors.New("connection reset by peer")
+	}
+	return sb.buff.Write(p)
+}
+
+func (sb *safeBuffer) String() string {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	return sb.buff.String()
+}
+
+func (sb *safeBuffer) breakConn() {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	sb.broken = true
+}
+
+func newRequest(t *testing.T, headerLines string) *request.Request {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(
+		"GET /events HTTP/1.1\r\nHost: localhost\r\n" + headerLines + "\r\n",
+	))
+	require.NoError(t, err)
+	return req
+}
+
+func TestStream(t *testing.T) {
+	// Test: Events are framed and flushed immediately
+	conn := &safeBuffer{}
+	s, err := NewStream(response.NewWriter(conn), newRequest(t, ""), 0)
+	require.NoError(t, err)
+	assert.Equal(t, "", s.LastEventID())
+	assert.Contains(t, conn.String(), "content-type: text/event-stream\r\n")
+	assert.Contains(t, conn.String(), "transfer-encoding: chunked\r\n")
+
+	require.NoError(t, s.Send(Event{ID: "1", Event: "update", Data: "line one\nline two", Retry: 3 * time.Second}))
+	event := "event: update\nid: 1\nretry: 3000\ndata: line one\ndata: line two\n\n"
+	assert.True(t, strings.HasSuffix(conn.String(), "\r\n\r\n"+
+		fmt.Sprintf("%x", len(event))+"\r\n"+event+"\r\n"))
+
+	require.NoError(t, s.Send(Event{Data: "a\r\nb\rc"}))
+	assert.Contains(t, conn.String(), "data: a\ndata: b\ndata: c\n\n")
+
+	// Test: Multi-line ids are refused
+	require.Error(t, s.Send(Event{ID: "1\n2", Data: "x"}))
+
+	// Test: Close ends the body
+	require.NoError(t, s.Close())
+	assert.True(t, strings.HasSuffix(conn.String(), "0\r\n\r\n"))
+	<-s.Done()
+	require.ErrorIs(t, s.Send(Event{Data: "late"}), ErrClosed)
+
+	// Test: Last-Event-ID is read from the request
+	conn = &safeBuffer{}
+	s, err = NewStream(response.NewWriter(conn), newRequest(t, "Last-Event-ID: 42\r\n"), 0)
+	require.NoError(t, err)
+	assert.Equal(t, "42", s.LastEventID())
+	s.Close()
+
+	// Test: Heartbeats and disconnect detection
+	conn = &safeBuffer{}
+	s, err = NewStream(response.NewWriter(conn), newRequest(t, ""), 5*time.Millisecond)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return strings.Contains(conn.String(), ": heartbeat\n\n")
+	}, time.Second, time.Millisecond)
+	conn.breakConn()
+	select {
+	case <-s.Done():
+	case <-time.After(time.Second):
+		t.Fatal("stream did not notice the client went away")
+	}
+	require.ErrorIs(t, s.Send(Event{Data: "x"}), ErrClosed)
+	require.NoError(t, s.Close())
+}
@@ -0,0 +1,367 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"unicode/utf8"
+)
+
+type MessageType int
+
+const (
+	TextMessage   MessageType = 1
+	BinaryMessage MessageType = 2
+)
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// Close status codes from RFC 6455 section 7.4.1.
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseNoStatus        = 1005
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+)
+
+// DefaultMaxMessageSize is the default limit on the size of a received
+// message, after reassembling its fragments.
+const DefaultMaxMessageSize = 1 << 20
+
+// maxControlPayload is the largest payload a control frame may carry.
+const maxControlPayload = 125
+
+var (
+	// ErrProtocol is returned when the peer breaks the framing rules. The
+	// connection is closed with status 1002 (or 1007 for invalid UTF-8).
+	ErrProtocol = errors.New("websocket protocol error")
+	// ErrMessageTooLarge is returned when a received message exceeds
+	// MaxMessageSize. The connection is closed with status 1009.
+	ErrMessageTooLarge = errors.New("websocket message too large")
+	// ErrClosed is returned when using a connection after it was closed.
+	ErrClosed = errors.New("websocket connection closed")
+)
+
+// CloseError is returned by ReadMessage when the peer closed the connection.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket closed by peer: %d %s", e.Code, e.Reason)
+}
+
+// Conn is a WebSocket connection. One goroutine may read while others write;
+// writes are serialized.
+type Conn struct {
+	conn     net.Conn
+	reader   *bufio.Reader
+	isServer bool
+
+	// MaxMessageSize limits the size of received messages.
+	MaxMessageSize int
+	// WriteFragmentSize splits sent messages into frames carrying at most
+	// this many bytes. With 0 every message is sent as a single frame.
+	WriteFragmentSize int
+
+	writeMu   sync.Mutex
+	closeSent bool
+}
+
+type frame struct {
+	fin     bool
+	opcode  byte
+	payload []byte
+}
+
+func newConn(conn net.Conn, isServer bool) *Conn {
+	return &Conn{
+		conn:           conn,
+		reader:         bufio.NewReader(conn),
+		isServer:       isServer,
+		MaxMessageSize: DefaultMaxMessageSize,
+	}
+}
+
+// NetConn returns the underlying network connection.
+func (c *Conn) NetConn() net.Conn {
+	return c.conn
+}
+
+// ReadMessage returns the next complete message, reassembled from its
+// fragments. Pings are answered and pongs skipped along the way. When the
+// peer closes the connection the close is echoed and a *CloseError returned.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	var messageType MessageType
+	var message []byte
+	for {
+		f, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch f.opcode {
+		case opPing:
+			if err := c.writeFrame(opPong, true, f.payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			return 0, nil, c.handleClose(f.payload)
+		case opText, opBinary:
+			if messageType != 0 {
+				return 0, nil, c.fail(CloseProtocolError, fmt.Errorf("%w: new message inside a fragmented one", ErrProtocol))
+			}
+			messageType = MessageType(f.opcode)
+		case opContinuation:
+			if messageType == 0 {
+				return 0, nil, c.fail(CloseProtocolError, fmt.Errorf("%w: continuation without a message", ErrProtocol))
+			}
+		default:
+			return 0, nil, c.fail(CloseProtocolError, fmt.Errorf("%w: unknown opcode %#x", ErrProtocol, f.opcode))
+		}
+
+		if len(message)+len(f.payload) > c.MaxMessageSize {
+			return 0, nil, c.fail(CloseMessageTooBig, ErrMessageTooLarge)
+		}
+		message = append(message, f.payload...)
+		if !f.fin {
+			continue
+		}
+		if messageType == TextMessage && !utf8.Valid(message) {
+			return 0, nil, c.fail(CloseInvalidPayload, fmt.Errorf("%w: text message is not valid UTF-8", ErrProtocol))
+		}
+		return messageType, message, nil
+	}
+}
+
+// WriteMessage sends data as a single message, fragmented according to
+// WriteFragmentSize.
+func (c *Conn) WriteMessage(messageType MessageType, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return fmt.Errorf("unknown message type %d", messageType)
+	}
+	if messageType == TextMessage && !utf8.Valid(data) {
+		return fmt.Errorf("text message is not valid UTF-8")
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	opcode := byte(messageType)
+	for {
+		fragment := data
+		if c.WriteFragmentSize > 0 && len(fragment) > c.WriteFragmentSize {
+			fragment = data[:c.WriteFragmentSize]
+		}
+		data = data[len(fragment):]
+		fin := len(data) == 0
+		if err := c.writeFrameLocked(opcode, fin, fragment); err != nil {
+			return err
+		}
+		if fin {
+			return nil
+		}
+		opcode = opContinuation
+	}
+}
+
+// Ping sends a ping frame, the peer answers with a pong carrying data.
+func (c *Conn) Ping(data []byte) error {
+	if len(data) > maxControlPayload {
+		return fmt.Errorf("ping payload longer than %d bytes", maxControlPayload)
+	}
+	return c.writeFrame(opPing, true, data)
+}
+
+// Close sends a close frame with code and reason, then closes the network
+// connection without waiting for the peer's reply.
+func (c *Conn) Close(code int, reason string) error {
+	err := c.sendClose(code, reason)
+	if closeErr := c.conn.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (c *Conn) sendClose(code int, reason string) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return nil
+	}
+	c.closeSent = true
+
+	var payload []byte
+	if code != CloseNoStatus {
+		payload = binary.BigEndian.AppendUint16(nil, uint16(code))
+		payload = append(payload, reason...)
+		if len(payload) > maxControlPayload {
+			payload = payload[:maxControlPayload]
+		}
+	}
+	return c.writeFrameLocked(opClose, true, payload)
+}
+
+// handleClose answers a close frame from the peer and closes the connection.
+func (c *Conn) handleClose(payload []byte) error {
+	closeErr := &CloseError{Code: CloseNoStatus}
+	switch {
+	case len(payload) == 1:
+		return c.fail(CloseProtocolError, fmt.Errorf("%w: truncated close frame", ErrProtocol))
+	case len(payload) >= 2:
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Reason = string(payload[2:])
+		if !utf8.ValidString(closeErr.Reason) {
+			return c.fail(CloseInvalidPayload, fmt.Errorf("%w: close reason is not valid UTF-8", ErrProtocol))
+		}
+	}
+	c.sendClose(closeErr.Code, "")
+	c.conn.Close()
+	return closeErr
+}
+
+// fail closes the connection with code after a protocol violation, and
+// returns err for the caller to report.
+func (c *Conn) fail(code int, err error) error {
+	c.sendClose(code, "")
+	c.conn.Close()
+	return err
+}
+
+func (c *Conn) readFrame() (frame, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return frame{}, err
+	}
+
+	f := frame{
+		fin:    header[0]&0x80 != 0,
+		opcode: header[0] & 0x0F,
+	}
+	if header[0]&0x70 != 0 {
+		return frame{}, c.fail(CloseProtocolError, fmt.Errorf("%w: reserved bits set", ErrProtocol))
+	}
+	masked := header[1]&0x80 != 0
+	if masked != c.isServer {
+		// clients must mask every frame, servers must never mask
+		return frame{}, c.fail(CloseProtocolError, fmt.Errorf("%w: wrong frame masking", ErrProtocol))
+	}
+
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return frame{}, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return frame{}, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+		if length>>63 != 0 {
+			return frame{}, c.fail(CloseProtocolError, fmt.Errorf("%w: invalid frame length", ErrProtocol))
+		}
+	}
+
+	if f.opcode >= opClose && (!f.fin || length > maxControlPayload) {
+		return frame{}, c.fail(CloseProtocolError, fmt.Errorf("%w: invalid control frame", ErrProtocol))
+	}
+	// checked before allocating, a single frame header can claim 2^63 bytes
+	if length > uint64(c.MaxMessageSize) {
+		return frame{}, c.fail(CloseMessageTooBig, ErrMessageTooLarge)
+	}
+
+	var maskKey [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.reader, maskKey[:]); err != nil {
+			return frame{}, err
+		}
+	}
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(c.reader, f.payload); err != nil {
+		return frame{}, err
+	}
+	if masked {
+		maskBytes(maskKey, f.payload)
+	}
+	return f, nil
+}
+
+func (c *Conn) writeFrame(opcode byte, fin bool, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.writeFrameLocked(opcode, fin, payload)
+}
+
+// writeFrameLocked must be called with writeMu held.
+func (c *Conn) writeFrameLocked(opcode byte, fin bool, payload []byte) error {
+	if c.closeSent && opcode != opClose {
+		return ErrClosed
+	}
+
+	buff := make([]byte, 0, 14+len(payload))
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+	buff = append(buff, first)
+
+	var maskBit byte
+	if !c.isServer {
+		maskBit = 0x80
+	}
+	switch {
+	case len(payload) <= 125:
+		buff = append(buff, maskBit|byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		buff = append(buff, maskBit|126)
+		buff = binary.BigEndian.AppendUint16(buff, uint16(len(payload)))
+	default:
+		buff = append(buff, maskBit|127)
+		buff = binary.BigEndian.AppendUint64(buff, uint64(len(payload)))
+	}
+
+	if c.isServer {
+		buff = append(buff, payload...)
+	} else {
+		var maskKey [4]byte
+		rand.Read(maskKey[:])
+		buff = append(buff, maskKey[:]...)
+		start := len(buff)
+		buff = append(buff, payload...)
+		maskBytes(maskKey, buff[start:])
+	}
+
+	_, err := c.conn.Write(buff)
+	return err
+}
+
+func maskBytes(key [4]byte, data []byte) {
+	for i := range data {
+		data[i] ^= key[i%4]
+	}
+}
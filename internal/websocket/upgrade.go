@@ -0,0 +1,85 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/xixotron/httpfromtcp/internal/headers"
+	"github.com/xixotron/httpfromtcp/internal/request"
+	"github.com/xixotron/httpfromtcp/internal/response"
+)
+
+// acceptGUID is appended to the client's key to compute Sec-WebSocket-Accept
+// (RFC 6455 section 1.3).
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// ErrBadHandshake is returned by Upgrade when the request is not a valid
+// WebSocket opening handshake. Upgrade has already answered it by then.
+var ErrBadHandshake = errors.New("bad websocket handshake")
+
+// Upgrade completes the WebSocket opening handshake for req, answering with
+// 101 Switching Protocols and taking over the connection. If req is not a
+// valid handshake it answers 400 (or 426 for an unsupported version) and
+// returns ErrBadHandshake.
+func Upgrade(w *response.Writer, req *request.Request) (*Conn, error) {
+	if req.RequestLine.Method != "GET" ||
+		!req.Headers.HasToken("Connection", "upgrade") ||
+		!req.Headers.HasToken("Upgrade", "websocket") {
+		writeError(w, response.StatusBadRequest, "Not a websocket handshake", nil)
+		return nil, fmt.Errorf("%w: missing upgrade headers", ErrBadHandshake)
+	}
+	if req.Headers.Get("Sec-WebSocket-Version") != "13" {
+		h := headers.NewHeaders()
+		h.Set("Sec-WebSocket-Version", "13")
+		writeError(w, response.StatusUpgradeRequired, "Unsupported websocket version", h)
+		return nil, fmt.Errorf("%w: unsupported version", ErrBadHandshake)
+	}
+	key := req.Headers.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		writeError(w, response.StatusBadRequest, "Invalid Sec-WebSocket-Key", nil)
+		return nil, fmt.Errorf("%w: invalid key", ErrBadHandshake)
+	}
+
+	h := headers.NewHeaders()
+	h.Set("Upgrade", "websocket")
+	h.Set("Connection", "Upgrade")
+	h.Set("Sec-WebSocket-Accept", AcceptKey(key))
+	if err := w.WriteStatusLine(response.StatusSwitchingProtocols); err != nil {
+		return nil, err
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return nil, err
+	}
+
+	conn, buffered, err := w.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	c := newConn(conn, true)
+	if len(buffered) > 0 {
+		// frames the client sent right after its handshake
+		c.reader = bufio.NewReader(io.MultiReader(bytes.NewReader(buffered), conn))
+	}
+	return c, nil
+}
+
+// AcceptKey computes the Sec-WebSocket-Accept value for a Sec-WebSocket-Key.
+func AcceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func writeError(w *response.Writer, statusCode response.StatusCode, message string, extra headers.Headers) {
+	h := response.GetDefaultHeaders(len(message))
+	for key, value := range extra {
+		h.Set(key, value)
+	}
+	w.WriteStatusLine(statusCode)
+	w.WriteHeaders(h)
+	w.WriteBody([]byte(message))
+}
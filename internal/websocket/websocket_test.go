@@ -0,0 +1,329 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xixotron/httpfromtcp/internal/request"
+	"github.com/xixotron/httpfromtcp/internal/response"
+)
+
+const testKey = "dGhlIHNhbXBsZSBub25jZQ=="
+
+func newRequest(t *testing.T, method string, headerLines ...string) *request.Request {
+	t.Helper()
+	raw := method + " /ws HTTP/1.1\r\nHost: localhost\r\n"
+	for _, line := range headerLines {
+		raw += line + "\r\n"
+	}
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(t, err)
+	return req
+}
+
+// connPair returns a server and a client Conn talking over an in-memory pipe.
+func connPair(t *testing.T) (*Conn, *Conn) {
+	t.Helper()
+	serverEnd, clientEnd := net.Pipe()
+	t.Cleanup(func() {
+		serverEnd.Close()
+		clientEnd.Close()
+	})
+	return newConn(serverEnd, true), newConn(clientEnd, false)
+}
+
+// writeRaw sends a hand-built frame from the client end, so tests can break
+// the framing rules on purpose. It blocks until the server has read it.
+func writeRaw(t *testing.T, conn net.Conn, first byte, masked bool, payload []byte) {
+	t.Helper()
+	frame := []byte{first}
+	maskBit := byte(0)
+	if masked {
+		maskBit = 0x80
+	}
+	switch {
+	case len(payload) <= 125:
+		frame = append(frame, maskBit|byte(len(payload)))
+	default:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	}
+	if masked {
+		key := [4]byte{1, 2, 3, 4}
+		frame = append(frame, key[:]...)
+		start := len(frame)
+		frame = append(frame, payload...)
+		maskBytes(key, frame[start:])
+	} else {
+		frame = append(frame, payload...)
+	}
+	_, err := conn.Write(frame)
+	require.NoError(t, err)
+}
+
+func TestAcceptKey(t *testing.T) {
+	// Test: Example from RFC 6455 section 1.3
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", AcceptKey(testKey))
+}
+
+func TestUpgrade(t *testing.T) {
+	// Test: Valid handshake switches protocols
+	serverEnd, clientEnd := net.Pipe()
+	defer clientEnd.Close()
+	req := newRequest(t, "GET",
+		"Connection: keep-alive, Upgrade",
+		"Upgrade: websocket",
+		"Sec-WebSocket-Version: 13",
+		"Sec-WebSocket-Key: "+testKey,
+	)
+	w := response.NewWriter(serverEnd)
+	upgraded := make(chan *Conn)
+	go func() {
+		conn, err := Upgrade(w, req)
+		assert.NoError(t, err)
+		upgraded <- conn
+	}()
+
+	reader := bufio.NewReader(clientEnd)
+	statusLine, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 101 Switching Protocols\r\n", statusLine)
+	var head strings.Builder
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\r\n" {
+			break
+		}
+		head.WriteString(line)
+	}
+	assert.Contains(t, head.String(), "sec-websocket-accept: s3pPLMBiTxaQ9kYGzzhZRbK+xOo=\r\n")
+	assert.Contains(t, head.String(), "upgrade: websocket\r\n")
+
+	server := <-upgraded
+	require.NotNil(t, server)
+	assert.True(t, w.Hijacked())
+	defer server.NetConn().Close()
+
+	// Test: Frames flow over the hijacked connection
+	client := newConn(clientEnd, false)
+	go client.WriteMessage(TextMessage, []byte("hello"))
+	messageType, data, err := server.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, TextMessage, messageType)
+	assert.Equal(t, "hello", string(data))
+}
+
+func TestUpgradeRejected(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		headerLines []string
+		status      string
+	}{
+		{
+			name:        "Missing Upgrade header",
+			method:      "GET",
+			headerLines: []string{"Connection: Upgrade", "Sec-WebSocket-Version: 13", "Sec-WebSocket-Key: " + testKey},
+			status:      "HTTP/1.1 400 Bad Request\r\n",
+		},
+		{
+			name:        "Wrong method",
+			method:      "POST",
+			headerLines: []string{"Connection: Upgrade", "Upgrade: websocket", "Sec-WebSocket-Version: 13", "Sec-WebSocket-Key: " + testKey},
+			status:      "HTTP/1.1 400 Bad Request\r\n",
+		},
+		{
+			name:        "Unsupported version",
+			method:      "GET",
+			headerLines: []string{"Connection: Upgrade", "Upgrade: websocket", "Sec-WebSocket-Version: 8", "Sec-WebSocket-Key: " + testKey},
+			status:      "HTTP/1.1 426 Upgrade Required\r\n",
+		},
+		{
+			name:        "Short key",
+			method:      "GET",
+			headerLines: []string{"Connection: Upgrade", "Upgrade: websocket", "Sec-WebSocket-Version: 13", "Sec-WebSocket-Key: c2hvcnQ="},
+			status:      "HTTP/1.1 400 Bad Request\r\n",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var out strings.Builder
+			w := response.NewWriter(&out)
+			conn, err := Upgrade(w, newRequest(t, tc.method, tc.headerLines...))
+			require.ErrorIs(t, err, ErrBadHandshake)
+			assert.Nil(t, conn)
+			assert.True(t, strings.HasPrefix(out.String(), tc.status))
+		})
+	}
+
+	// Test: 426 advertises the supported version
+	var out strings.Builder
+	Upgrade(response.NewWriter(&out), newRequest(t, "GET",
+		"Connection: Upgrade", "Upgrade: websocket", "Sec-WebSocket-Version: 8", "Sec-WebSocket-Key: "+testKey))
+	assert.Contains(t, out.String(), "sec-websocket-version: 13\r\n")
+}
+
+func TestMessages(t *testing.T) {
+	server, client := connPair(t)
+
+	// Test: Client frames are masked and read back by the server
+	go client.WriteMessage(BinaryMessage, []byte{0, 1, 2, 3})
+	messageType, data, err := server.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, BinaryMessage, messageType)
+	assert.Equal(t, []byte{0, 1, 2, 3}, data)
+
+	// Test: Large messages use the extended length
+	large := strings.Repeat("x", 70000)
+	go server.WriteMessage(TextMessage, []byte(large))
+	messageType, data, err = client.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, TextMessage, messageType)
+	assert.Equal(t, large, string(data))
+
+	// Test: Fragmented messages are reassembled
+	client.WriteFragmentSize = 3
+	go client.WriteMessage(TextMessage, []byte("fragmented"))
+	messageType, data, err = server.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, TextMessage, messageType)
+	assert.Equal(t, "fragmented", string(data))
+
+	// Test: Invalid UTF-8 is refused before sending
+	require.Error(t, client.WriteMessage(TextMessage, []byte{0xff, 0xfe}))
+}
+
+func TestPingPong(t *testing.T) {
+	server, client := connPair(t)
+	clientEnd := client.NetConn()
+
+	// Test: Ping between fragments is answered and the message still arrives
+	done := make(chan []byte)
+	go func() {
+		_, data, err := server.ReadMessage()
+		assert.NoError(t, err)
+		done <- data
+	}()
+	writeRaw(t, clientEnd, opText, true, []byte("hel"))
+	writeRaw(t, clientEnd, 0x80|opPing, true, []byte("are you there"))
+
+	pong := make([]byte, 2+len("are you there"))
+	_, err := io.ReadFull(clientEnd, pong)
+	require.NoError(t, err)
+	assert.Equal(t, byte(0x80|opPong), pong[0])
+	assert.Equal(t, "are you there", string(pong[2:]))
+
+	writeRaw(t, clientEnd, 0x80|opContinuation, true, []byte("lo"))
+	assert.Equal(t, "hello", string(<-done))
+}
+
+func TestClose(t *testing.T) {
+	server, client := connPair(t)
+
+	// Test: Peer close is echoed and reported
+	readErr := make(chan error)
+	go func() {
+		_, _, err := server.ReadMessage()
+		readErr <- err
+	}()
+	go client.sendClose(CloseGoingAway, "bye")
+	_, _, err := client.ReadMessage()
+	var closeErr *CloseError
+	require.ErrorAs(t, err, &closeErr)
+	assert.Equal(t, CloseGoingAway, closeErr.Code)
+
+	err = <-readErr
+	require.ErrorAs(t, err, &closeErr)
+	assert.Equal(t, CloseGoingAway, closeErr.Code)
+	assert.Equal(t, "bye", closeErr.Reason)
+
+	// Test: Writing after close fails
+	require.ErrorIs(t, server.WriteMessage(TextMessage, []byte("late")), ErrClosed)
+}
+
+func TestProtocolErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		first   byte
+		masked  bool
+		payload []byte
+		err     error
+		code    int
+	}{
+		{
+			name:   "Unmasked client frame",
+			first:  0x80 | opText,
+			masked: false,
+			err:    ErrProtocol,
+			code:   CloseProtocolError,
+		},
+		{
+			name:   "Reserved bits",
+			first:  0xC0 | opText,
+			masked: true,
+			err:    ErrProtocol,
+			code:   CloseProtocolError,
+		},
+		{
+			name:   "Fragmented control frame",
+			first:  opPing,
+			masked: true,
+			err:    ErrProtocol,
+			code:   CloseProtocolError,
+		},
+		{
+			name:   "Unexpected continuation",
+			first:  0x80 | opContinuation,
+			masked: true,
+			err:    ErrProtocol,
+			code:   CloseProtocolError,
+		},
+		{
+			name:    "Invalid UTF-8 text",
+			first:   0x80 | opText,
+			masked:  true,
+			payload: []byte{0xc3, 0x28},
+			err:     ErrProtocol,
+			code:    CloseInvalidPayload,
+		},
+		{
+			name:    "Message too large",
+			first:   0x80 | opBinary,
+			masked:  true,
+			payload: make([]byte, 200),
+			err:     ErrMessageTooLarge,
+			code:    CloseMessageTooBig,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			server, client := connPair(t)
+			server.MaxMessageSize = 100
+			clientEnd := client.NetConn()
+			readErr := make(chan error)
+			go func() {
+				_, _, err := server.ReadMessage()
+				readErr <- err
+			}()
+			writeRaw(t, clientEnd, tc.first, tc.masked, tc.payload)
+
+			// the server closes with a status code before dropping the connection
+			reply := make([]byte, 4)
+			_, err := io.ReadFull(clientEnd, reply)
+			require.NoError(t, err)
+			assert.Equal(t, byte(0x80|opClose), reply[0])
+			assert.Equal(t, tc.code, int(binary.BigEndian.Uint16(reply[2:])))
+			require.ErrorIs(t, <-readErr, tc.err)
+		})
+	}
+}